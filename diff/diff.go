@@ -0,0 +1,132 @@
+package diff
+
+import "strings"
+
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// past this many lines added or removed the texts are shown as fully
+// replaced, it bounds the time and memory of a diff whatever the texts
+const maxEdits = 500
+
+// Op is a line kept, added or removed between two texts
+type Op struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Lines returns the operations that turn the lines of a into the lines of b
+func Lines(a, b string) []Op {
+	return diff(strings.Split(a, "\n"), strings.Split(b, "\n"))
+}
+
+func diff(a, b []string) []Op {
+	// the lines shared at the start and at the end are kept as they are
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []Op{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, Op{Type: Equal, Text: line})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, Op{Type: Equal, Text: line})
+	}
+	return ops
+}
+
+// myers finds the shortest list of operations with the greedy algorithm of
+// Myers, in O((len(a)+len(b))*D) time and O(D²) memory for D edits. It gives
+// up past maxEdits and replaces all the lines instead.
+func myers(a, b []string) []Op {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+
+	// v[offset+k] is the furthest x reached on the diagonal k = x-y, trace[d]
+	// keeps the diagonals -d..d after d edits to walk the path back
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	trace := [][]int{}
+	for d := 0; d <= limit; d++ {
+		done := false
+		for k := -d; k <= d; k += 2 {
+			x := v[offset+k-1] + 1
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			done = done || (x >= n && y >= m)
+		}
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+		if done {
+			return backtrack(a, b, trace)
+		}
+	}
+
+	ops := []Op{}
+	for _, line := range a {
+		ops = append(ops, Op{Type: Delete, Text: line})
+	}
+	for _, line := range b {
+		ops = append(ops, Op{Type: Insert, Text: line})
+	}
+	return ops
+}
+
+// walk the path found by myers back from the end of both texts
+func backtrack(a, b []string, trace [][]int) []Op {
+	reversed := []Op{}
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		previous := trace[d-1]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && previous[d-1+k-1] < previous[d-1+k+1]) {
+			prevK = k + 1
+		}
+		// the edit moved down from the previous diagonal for an insert or
+		// right for a delete, then followed the equal lines
+		startX := previous[d-1+prevK]
+		if prevK == k-1 {
+			startX++
+		}
+		for x > startX {
+			reversed = append(reversed, Op{Type: Equal, Text: a[x-1]})
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			reversed = append(reversed, Op{Type: Insert, Text: b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, Op{Type: Delete, Text: a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, Op{Type: Equal, Text: a[x-1]})
+		x--
+		y--
+	}
+
+	ops := make([]Op, 0, len(reversed))
+	for i := len(reversed) - 1; i >= 0; i-- {
+		ops = append(ops, reversed[i])
+	}
+	return ops
+}
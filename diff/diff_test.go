@@ -0,0 +1,50 @@
+package diff
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []Op
+	}{
+		{"a", "a", []Op{{Equal, "a"}}},
+		{"a", "b", []Op{{Delete, "a"}, {Insert, "b"}}},
+		{"a\nb\nc", "a\nc", []Op{{Equal, "a"}, {Delete, "b"}, {Equal, "c"}}},
+		{"a\nc", "a\nb\nc", []Op{{Equal, "a"}, {Insert, "b"}, {Equal, "c"}}},
+		{"a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc", []Op{
+			{Delete, "a"}, {Delete, "b"}, {Equal, "c"}, {Insert, "b"}, {Equal, "a"},
+			{Equal, "b"}, {Delete, "b"}, {Equal, "a"}, {Insert, "c"},
+		}},
+	}
+	for _, test := range tests {
+		if got := Lines(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Lines(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestLinesTooManyEdits(t *testing.T) {
+	// texts with nothing in common past maxEdits are fully replaced
+	a, b := []string{}, []string{}
+	for i := 0; i < maxEdits; i++ {
+		a = append(a, "a"+strconv.Itoa(i))
+		b = append(b, "b"+strconv.Itoa(i))
+	}
+	ops := diff(append([]string{"same"}, a...), append([]string{"same"}, b...))
+	if len(ops) != 1+2*maxEdits || ops[0].Type != Equal {
+		t.Fatalf("got %d operations, want %d", len(ops), 1+2*maxEdits)
+	}
+	for i, op := range ops[1:] {
+		want := Delete
+		if i >= maxEdits {
+			want = Insert
+		}
+		if op.Type != want {
+			t.Fatalf("operation %d is %s, want %s", i+1, op.Type, want)
+		}
+	}
+}
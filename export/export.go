@@ -0,0 +1,391 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"containerized-go-app/models"
+	"containerized-go-app/storage"
+	"context"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+// how long an archive can be downloaded
+const Retention = 7 * 24 * time.Hour
+
+// how long to wait when there is nothing to build or the database failed, and
+// after how long an export started by a worker that died is taken over
+const idleDelay = time.Hour
+const retryDelay = time.Minute
+const staleDelay = 10 * time.Minute
+
+// Exporter builds the archives of the pending exports. Like the scheduler it
+// keeps nothing in memory, the exports asked before a restart are built once
+// the server is back.
+type Exporter struct {
+	db    *mongo.Database
+	store storage.Storage
+	wake  chan struct{}
+}
+
+func New(db *mongo.Database, store storage.Storage) *Exporter {
+	return &Exporter{db: db, store: store, wake: make(chan struct{}, 1)}
+}
+
+// Wake makes the exporter look for pending exports, it must be called when an export is asked
+func (e *Exporter) Wake() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run builds the pending exports and removes the expired ones, until ctx is done
+func (e *Exporter) Run(ctx context.Context) {
+	for {
+		delay := idleDelay
+		if err := e.buildPending(ctx); err != nil {
+			log.Println("export:", err)
+			delay = retryDelay
+		} else if err := e.purgeExpired(ctx); err != nil {
+			log.Println("export:", err)
+			delay = retryDelay
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-e.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// build the pending exports one at a time, each one is claimed first so
+// several servers do not build the same archive
+func (e *Exporter) buildPending(ctx context.Context) error {
+	exportCollection := e.db.Collection("Export")
+	for {
+		now := time.Now()
+		export := models.Export{}
+		err := exportCollection.FindOneAndUpdate(ctx,
+			bson.M{
+				"status": models.ExportStatusPending,
+				"$or": bson.A{
+					bson.M{"startedAt": nil},
+					bson.M{"startedAt": bson.M{"$lt": now.Add(-staleDelay)}},
+				},
+			},
+			bson.M{"$set": bson.M{"startedAt": now}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetReturnDocument(options.After)).Decode(&export)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = e.build(ctx, export); err != nil {
+			return err
+		}
+	}
+}
+
+// build the archive of an export, save it then tell the user
+func (e *Exporter) build(ctx context.Context, export models.Export) error {
+	files, err := collect(ctx, e.db, export.UserId)
+	if err == mongo.ErrNoDocuments {
+		// the account was deleted in the meantime
+		_, err = e.db.Collection("Export").DeleteOne(ctx, bson.M{"_id": export.ID})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	archive := bytes.Buffer{}
+	writer := zip.NewWriter(&archive)
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			return err
+		}
+		w, err := writer.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	size := int64(archive.Len())
+	if err = e.store.Save(export.Key(), &archive); err != nil {
+		return err
+	}
+
+	readyAt := time.Now()
+	_, err = e.db.Collection("Export").UpdateOne(ctx,
+		bson.M{"_id": export.ID},
+		bson.M{
+			"$set":   bson.M{"status": models.ExportStatusReady, "readyAt": readyAt, "expiresAt": readyAt.Add(Retention), "size": size},
+			"$unset": bson.M{"startedAt": ""},
+		})
+	if err != nil {
+		return err
+	}
+
+	// a single notification even if the export was built twice
+	_, err = e.db.Collection("Notification").UpdateOne(ctx,
+		bson.M{"userId": export.UserId, "type": models.NotificationExportReady, "exportId": export.ID.Hex()},
+		bson.M{"$setOnInsert": bson.M{"createdAt": readyAt}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// remove the archives that cannot be downloaded anymore
+func (e *Exporter) purgeExpired(ctx context.Context) error {
+	exportCollection := e.db.Collection("Export")
+	cursor, err := exportCollection.Find(ctx, bson.M{"expiresAt": bson.M{"$lt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	exports := []models.Export{}
+	if err = cursor.All(ctx, &exports); err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err = e.store.Delete(export.Key()); err != nil {
+			return err
+		}
+		if _, err = exportCollection.DeleteOne(ctx, bson.M{"_id": export.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAll removes the exports of a user with their archives and notifications
+func DeleteAll(ctx context.Context, db *mongo.Database, store storage.Storage, userId string) error {
+	exportCollection := db.Collection("Export")
+	cursor, err := exportCollection.Find(ctx, bson.M{"userId": userId})
+	if err != nil {
+		return err
+	}
+	exports := []models.Export{}
+	if err = cursor.All(ctx, &exports); err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err = store.Delete(export.Key()); err != nil {
+			return err
+		}
+	}
+	if _, err = exportCollection.DeleteMany(ctx, bson.M{"userId": userId}); err != nil {
+		return err
+	}
+	_, err = db.Collection("Notification").DeleteMany(ctx, bson.M{"userId": userId})
+	return err
+}
+
+type file struct {
+	name string
+	data interface{}
+}
+
+// post is a post of the user as exported, the voters of the post and of its
+// poll are counted and not named
+type post struct {
+	ID           string              `json:"id"`
+	CreatedAt    time.Time           `json:"createdAt"`
+	Title        string              `json:"title"`
+	Content      string              `json:"content"`
+	Tags         []string            `json:"tags"`
+	Status       string              `json:"status"`
+	PublishAt    *time.Time          `json:"publishAt,omitempty"`
+	EditedAt     *time.Time          `json:"editedAt,omitempty"`
+	DeletedAt    *time.Time          `json:"deletedAt,omitempty"`
+	Pinned       bool                `json:"pinned"`
+	Locked       bool                `json:"locked"`
+	CommentCount int                 `json:"commentCount"`
+	UpVotes      int                 `json:"upVotes"`
+	DownVotes    int                 `json:"downVotes"`
+	Score        int                 `json:"score"`
+	Attachments  []models.Attachment `json:"attachments"`
+	Poll         *poll               `json:"poll,omitempty"`
+}
+
+// poll is the poll of a post with its tallies, they are only exported once the
+// poll is closed or the user voted like on the site
+type poll struct {
+	Options  []pollOption `json:"options"`
+	Multiple bool         `json:"multiple"`
+	ClosesAt *time.Time   `json:"closesAt,omitempty"`
+	Voters   *int         `json:"voters,omitempty"`
+}
+
+type pollOption struct {
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+func newPost(p models.Post, userId string) post {
+	exported := post{
+		ID:           p.ID.Hex(),
+		CreatedAt:    p.CreatedAt,
+		Title:        p.Title,
+		Content:      p.Content,
+		Tags:         p.Tags,
+		Status:       p.Status,
+		PublishAt:    p.PublishAt,
+		EditedAt:     p.EditedAt,
+		DeletedAt:    p.DeletedAt,
+		Pinned:       p.Pinned,
+		Locked:       p.Locked,
+		CommentCount: p.CommentCount,
+		UpVotes:      len(p.UpVotes),
+		DownVotes:    len(p.DownVotes),
+		Score:        p.Score,
+		Attachments:  p.Attachments,
+	}
+	if exported.Tags == nil {
+		exported.Tags = []string{}
+	}
+	if exported.Attachments == nil {
+		exported.Attachments = []models.Attachment{}
+	}
+	if p.Poll == nil {
+		return exported
+	}
+
+	voted := false
+	for _, ballot := range p.Poll.Ballots {
+		voted = voted || ballot.UserId == userId
+	}
+	showResults := voted || (p.Poll.ClosesAt != nil && !p.Poll.ClosesAt.After(time.Now()))
+	exported.Poll = &poll{Options: []pollOption{}, Multiple: p.Poll.Multiple, ClosesAt: p.Poll.ClosesAt}
+	if showResults {
+		voters := len(p.Poll.Ballots)
+		exported.Poll.Voters = &voters
+	}
+	for _, option := range p.Poll.Options {
+		exportedOption := pollOption{Text: option.Text}
+		if showResults {
+			votes := option.Votes
+			exportedOption.Votes = &votes
+		}
+		exported.Poll.Options = append(exported.Poll.Options, exportedOption)
+	}
+	return exported
+}
+
+// vote is a post the user voted on, only its id and title are exported
+type vote struct {
+	PostId string `json:"postId"`
+	Title  string `json:"title"`
+	Vote   string `json:"vote,omitempty"`
+	// the options chosen in the poll of the post
+	Choices []string `json:"choices,omitempty"`
+}
+
+// read everything the user wrote or did, the password hash is left out
+func collect(ctx context.Context, db *mongo.Database, userId string) ([]file, error) {
+	objId, _ := primitive.ObjectIDFromHex(userId)
+	user := models.User{}
+	if err := db.Collection("User").FindOne(ctx, bson.M{"_id": objId}).Decode(&user); err != nil {
+		return nil, err
+	}
+	profile := map[string]interface{}{
+		"id":        userId,
+		"createdAt": user.CreatedAt,
+		"email":     user.Email,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+		"role":      user.Role,
+	}
+
+	// every post of the user, drafts and trash included
+	postCollection := db.Collection("Post")
+	sort := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	written := []models.Post{}
+	if err := findAll(ctx, postCollection, bson.M{"userId": userId}, sort, &written); err != nil {
+		return nil, err
+	}
+	posts := []post{}
+	for _, p := range written {
+		posts = append(posts, newPost(p, userId))
+	}
+	comments := []models.Comment{}
+	if err := findAll(ctx, db.Collection("Comment"), bson.M{"userId": userId}, sort, &comments); err != nil {
+		return nil, err
+	}
+
+	voted := []models.Post{}
+	err := findAll(ctx, postCollection,
+		bson.M{"$or": bson.A{bson.M{"upVotes": userId}, bson.M{"downVotes": userId}, bson.M{"poll.ballots.userId": userId}}},
+		sort, &voted)
+	if err != nil {
+		return nil, err
+	}
+	votes := []vote{}
+	for _, post := range voted {
+		v := vote{PostId: post.ID.Hex(), Title: post.Title}
+		for _, id := range post.UpVotes {
+			if id == userId {
+				v.Vote = "up"
+			}
+		}
+		for _, id := range post.DownVotes {
+			if id == userId {
+				v.Vote = "down"
+			}
+		}
+		if post.Poll != nil {
+			for _, ballot := range post.Poll.Ballots {
+				if ballot.UserId != userId {
+					continue
+				}
+				for _, choice := range ballot.Choices {
+					if choice >= 0 && choice < len(post.Poll.Options) {
+						v.Choices = append(v.Choices, post.Poll.Options[choice].Text)
+					}
+				}
+			}
+		}
+		votes = append(votes, v)
+	}
+
+	bookmarks := []models.Bookmark{}
+	if err = findAll(ctx, db.Collection("Bookmark"), bson.M{"userId": userId}, sort, &bookmarks); err != nil {
+		return nil, err
+	}
+	saved := []map[string]interface{}{}
+	for _, bookmark := range bookmarks {
+		saved = append(saved, map[string]interface{}{"postId": bookmark.PostId, "createdAt": bookmark.CreatedAt})
+	}
+
+	return []file{
+		{"profile.json", profile},
+		{"posts.json", posts},
+		{"comments.json", comments},
+		{"votes.json", votes},
+		{"bookmarks.json", saved},
+	}, nil
+}
+
+func findAll(ctx context.Context, collection *mongo.Collection, filter bson.M, findOptions *options.FindOptions, results interface{}) error {
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
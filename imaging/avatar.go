@@ -0,0 +1,81 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+)
+
+// AvatarSizes are the sides in pixels of the square copies made of an avatar, smallest first
+var AvatarSizes = []int{64, 128, 256}
+
+// DefaultAvatarSize is the size served when none is asked
+const DefaultAvatarSize = 128
+
+// Avatar decodes an uploaded picture, crops its center to a square and makes a
+// copy of it at each of the AvatarSizes. JPEG stays JPEG with its orientation
+// applied, the other types become PNG.
+func Avatar(data []byte, contentType string) (map[int]Encoded, error) {
+	img, err := decode(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	encodedType := "image/png"
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+		encodedType = "image/jpeg"
+	}
+
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	square := image.Rect(x, y, x+side, y+side)
+
+	avatars := map[int]Encoded{}
+	for _, size := range AvatarSizes {
+		dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, square, draw.Src, nil)
+		avatars[size], err = encode(dst, encodedType, 90)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return avatars, nil
+}
+
+// the identicon is a grid of identiconCells by identiconCells, mirrored on its vertical axis
+const identiconCells = 5
+
+// Identicon draws the default avatar of a user from a seed, the user id, so it
+// is the same every time. Its color and its cells are taken from a hash of the seed.
+func Identicon(seed string, size int) (Encoded, error) {
+	hash := sha256.Sum256([]byte(seed))
+
+	// keep the color away from white so it shows on the background
+	foreground := color.NRGBA{R: hash[0]/2 + 32, G: hash[1]/2 + 32, B: hash[2]/2 + 32, A: 255}
+	background := color.NRGBA{R: 240, G: 240, B: 240, A: 255}
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	// a half cell of margin on each side
+	cell := size / (identiconCells + 1)
+	margin := (size - cell*identiconCells) / 2
+	bit := 0
+	for column := 0; column < (identiconCells+1)/2; column++ {
+		for row := 0; row < identiconCells; row++ {
+			on := hash[3+bit/8]&(1<<(bit%8)) != 0
+			bit++
+			if !on {
+				continue
+			}
+			for _, c := range []int{column, identiconCells - 1 - column} {
+				rect := image.Rect(margin+c*cell, margin+row*cell, margin+(c+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, rect, image.NewUniform(foreground), image.Point{}, draw.Src)
+			}
+		}
+	}
+	return encode(img, "image/png", 0)
+}
@@ -0,0 +1,95 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+)
+
+var errInvalidGIF = errors.New("invalid gif")
+
+// the application extensions that only tell how many times an animation loops
+var loopExtensions = [][]byte{[]byte("NETSCAPE2.0"), []byte("ANIMEXTS1.0")}
+
+// stripGIF copies a GIF without its comment, plain text and application
+// extensions, which can hold XMP (GPS position, ...) or any other text. The
+// frames and their timing are copied byte for byte so animations are kept
+// without decoding them.
+func stripGIF(data []byte) ([]byte, error) {
+	// header and logical screen descriptor, then the global color table
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, errInvalidGIF
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errInvalidGIF
+	}
+	stripped := append([]byte{}, data[:i]...)
+
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3B:
+			// trailer, anything after it is dropped
+			return append(stripped, 0x3B), nil
+		case 0x2C:
+			// image descriptor, its local color table, the LZW code size then the data
+			if i+10 > len(data) {
+				return nil, errInvalidGIF
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+			end, err := skipSubBlocks(data, i)
+			if err != nil {
+				return nil, err
+			}
+			stripped = append(stripped, data[start:end]...)
+			i = end
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, errInvalidGIF
+			}
+			label := data[i+1]
+			end, err := skipSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			// the graphic control extension holds the delay and the transparency of a frame
+			keep := label == 0xF9
+			if label == 0xFF && i+3 < len(data) {
+				identifier := data[i+3 : min(i+3+int(data[i+2]), len(data))]
+				for _, loop := range loopExtensions {
+					keep = keep || bytes.Equal(identifier, loop)
+				}
+			}
+			if keep {
+				stripped = append(stripped, data[start:end]...)
+			}
+			i = end
+		default:
+			return nil, errInvalidGIF
+		}
+	}
+	// a GIF cut before its trailer is still shown by browsers
+	return append(stripped, 0x3B), nil
+}
+
+// get the position after a chain of data sub-blocks, it ends with an empty block
+func skipSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errInvalidGIF
+		}
+		size := int(data[i])
+		i += 1 + size
+		if size == 0 {
+			return i, nil
+		}
+	}
+}
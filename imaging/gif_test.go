@@ -0,0 +1,56 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestStripGIF(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.SetColorIndex(i, i, 1)
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// a comment and an XMP block before the first frame
+	comment := append([]byte{0x21, 0xFE, 8}, []byte("lat 48.8")...)
+	comment = append(comment, 0)
+	xmp := append([]byte{0x21, 0xFF, 11}, []byte("XMP DataXMP")...)
+	xmp = append(xmp, 4, '<', 'x', '/', '>', 0)
+	first := bytes.IndexByte(data[13+6:], 0x21) + 13 + 6
+	injected := append(append(append([]byte{}, data[:first]...), comment...), xmp...)
+	injected = append(injected, data[first:]...)
+
+	stripped, err := stripGIF(injected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, []byte("lat 48.8")) || bytes.Contains(stripped, []byte("XMP DataXMP")) {
+		t.Fatal("metadata was not stripped")
+	}
+	if !bytes.Contains(stripped, []byte("NETSCAPE2.0")) {
+		t.Fatal("loop extension was stripped")
+	}
+	decoded, err := gif.DecodeAll(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != 3 || decoded.Delay[2] != 10 {
+		t.Fatalf("got %d frames, want 3", len(decoded.Image))
+	}
+
+	if _, err := stripGIF(injected[:len(injected)/2]); err == nil {
+		t.Fatal("expected an error for a truncated gif")
+	}
+}
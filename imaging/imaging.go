@@ -0,0 +1,153 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Size is a resized copy of an uploaded image, bounded to MaxSize pixels on its longest side
+type Size struct {
+	Name    string
+	MaxSize int
+}
+
+// Sizes are the variants made for every uploaded image, smallest first
+var Sizes = []Size{
+	{Name: "thumb", MaxSize: 320},
+	{Name: "medium", MaxSize: 1024},
+}
+
+// decoding allocates 4 bytes per pixel, bigger images are refused before decoding
+const maxPixels = 40_000_000
+
+var (
+	ErrUnsupported = errors.New("unsupported image type")
+	ErrTooLarge    = errors.New("image too large")
+)
+
+// Encoded is an image encoded again, without any of the metadata of the upload
+type Encoded struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Result is an uploaded image cleaned of its metadata, with its variants by size name
+type Result struct {
+	Original Encoded
+	Variants map[string]Encoded
+}
+
+// Supported tells if variants can be made for a sniffed content type
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Process decodes an uploaded image and encodes it again with its variants.
+// JPEG and PNG are encoded again so EXIF (GPS position, camera, ...) and other
+// metadata are dropped, the EXIF orientation is applied to the pixels first.
+// WebP is encoded as PNG for the same reason, there is no WebP encoder.
+// GIF has no EXIF but its extensions can hold XMP or comments, they are
+// removed and the frames are kept as they are to keep the animation.
+func Process(data []byte, contentType string) (*Result, error) {
+	img, err := decode(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Variants: map[string]Encoded{}}
+	switch contentType {
+	case "image/jpeg":
+		img = orient(img, jpegOrientation(data))
+		result.Original, err = encode(img, contentType, 90)
+	case "image/png", "image/webp":
+		result.Original, err = encode(img, "image/png", 0)
+	default:
+		bounds := img.Bounds()
+		var stripped []byte
+		stripped, err = stripGIF(data)
+		result.Original = Encoded{Data: stripped, ContentType: contentType, Width: bounds.Dx(), Height: bounds.Dy()}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// GIF and WebP variants are PNG, only the first frame of a GIF is kept
+	variantType := result.Original.ContentType
+	if variantType == "image/gif" {
+		variantType = "image/png"
+	}
+	for _, size := range Sizes {
+		variant, err := encode(resize(img, size.MaxSize), variantType, 80)
+		if err != nil {
+			return nil, err
+		}
+		result.Variants[size.Name] = variant
+	}
+	return result, nil
+}
+
+// decode an uploaded image after checking its type and its dimensions, only
+// the first frame of a GIF is decoded
+func decode(data []byte, contentType string) (image.Image, error) {
+	if !Supported(contentType) {
+		return nil, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// scale an image down so its longest side is at most maxSize, smaller images are kept as is
+func resize(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+	if width >= height {
+		height = max(height*maxSize/width, 1)
+		width = maxSize
+	} else {
+		width = max(width*maxSize/height, 1)
+		height = maxSize
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func encode(img image.Image, contentType string, quality int) (Encoded, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	} else {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	}
+	if err != nil {
+		return Encoded{}, err
+	}
+	bounds := img.Bounds()
+	return Encoded{Data: buf.Bytes(), ContentType: contentType, Width: bounds.Dx(), Height: bounds.Dy()}, nil
+}
@@ -38,11 +38,11 @@ func GetUserID(tokenString string, client *mongo.Client) (string, error) {
 	}
 	claims := token.Claims.(jtoken.MapClaims)
 
-	//check if userId exist in the db
-	userCollection := client.Database("keduback").Collection("User")
+	//check if userId exist in the db and is not deactivated or being deleted
+	userCollection := client.Database(os.Getenv("DB_NAME")).Collection("User")
 	objId, _ := primitive.ObjectIDFromHex(claims["ID"].(string))
 	user := models.User{}
-	err = userCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&user)
+	err = userCollection.FindOne(context.Background(), bson.M{"_id": objId, "deactivatedAt": nil, "deletion": nil}).Decode(&user)
 	if err != nil {
 		return "", err
 	}
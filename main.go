@@ -1,14 +1,22 @@
 package main
 
 import (
+	"containerized-go-app/export"
+	"containerized-go-app/migration"
+	"containerized-go-app/models"
 	"containerized-go-app/router"
+	"containerized-go-app/scheduler"
+	"containerized-go-app/storage"
+	"containerized-go-app/trash"
 	"context"
 	"errors"
+	"flag"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
@@ -40,6 +48,95 @@ func connectToDB() (*mongo.Database, error) {
 	return db, err
 }
 
+func createIndexes(db *mongo.Database) error {
+	ctx := context.Background()
+
+	// comments are read by post, thread and creation date, and updated by author
+	_, err := db.Collection("Comment").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "postId", Value: 1}, {Key: "createdAt", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "rootId", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// feeds show the published posts of the active users newest first for everyone, one user or one tag, or by
+	// precomputed scores, the scheduler reads the scheduled posts by date
+	_, err = db.Collection("Post").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "authorHidden", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "authorHidden", Value: 1}, {Key: "tags", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "authorHidden", Value: 1}, {Key: "hotScore", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "authorHidden", Value: 1}, {Key: "score", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "publishAt", Value: 1}}},
+		// only the posts in the trash have a deletedAt, the purge reads them by date
+		{Keys: bson.D{{Key: "deletedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// only the posts of the deactivated users have the field, they are read by author
+		{Keys: bson.D{{Key: "authorHidden", Value: 1}, {Key: "userId", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// only the pinned posts have the field, they are read newest first
+		{Keys: bson.D{{Key: "pinned", Value: 1}, {Key: "createdAt", Value: -1}}, Options: options.Index().SetSparse(true)},
+		{
+			Keys: bson.D{{Key: "title", Value: "text"}, {Key: "content", Value: "text"}},
+			Options: options.Index().
+				SetName("post_text").
+				SetWeights(bson.D{{Key: "title", Value: 3}, {Key: "content", Value: 1}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// a user saves a post once, the saved posts are read newest first
+	_, err = db.Collection("Bookmark").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "postId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "postId", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// only the accounts deactivated or being deleted have the fields, the deactivated
+	// ones are hidden from the feeds and purged by date, the deletions are resumed after a failure
+	_, err = db.Collection("User").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "deactivatedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "deletion", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// a user has one export being built at a time, the worker reads the pending ones
+	// in order and removes the expired ones
+	_, err = db.Collection("Export").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": models.ExportStatusPending}),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the notifications of a user are read newest first
+	_, err = db.Collection("Notification").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the history of a post is read in order
+	_, err = db.Collection("PostRevision").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "postId", Value: 1}, {Key: "revisedAt", Value: 1}},
+	})
+	return err
+}
+
 func main() {
 	err := run()
 	if err != nil {
@@ -48,6 +145,11 @@ func main() {
 }
 
 func run() error {
+	migrateComments := flag.Bool("migrate-comments", false, "move the comments embedded in posts to the Comment collection and exit")
+	syncAuthorNames := flag.Bool("sync-author-names", false, "copy the first name of every user on its posts and comments and exit")
+	backfill := flag.Bool("backfill", false, "store the score, rendered content and status of the posts and comments written before them and exit")
+	flag.Parse()
+
 	// load env variables
 	err := godotenv.Load()
 	if err != nil {
@@ -63,9 +165,57 @@ func run() error {
 	//defer disconnect
 	defer db.Client().Disconnect(context.Background())
 
-	app := fiber.New()
+	if err := createIndexes(db); err != nil {
+		return err
+	}
+
+	if *migrateComments {
+		return migration.MigrateComments(db)
+	}
+
+	if *syncAuthorNames {
+		return migration.SyncAuthorNames(db)
+	}
+
+	if *backfill {
+		if err := migration.BackfillPostScores(db); err != nil {
+			return err
+		}
+		if err := migration.BackfillContentHtml(db); err != nil {
+			return err
+		}
+		return migration.BackfillPostStatus(db)
+	}
+
+	// publish the scheduled posts, including the ones that were due while the server was down
+	publisher := scheduler.New(db)
+	go publisher.Run(context.Background())
+
+	// uploaded files are kept on the local filesystem
+	uploadDir := os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = "uploads"
+	}
+	store, err := storage.NewLocal(uploadDir)
+	if err != nil {
+		return err
+	}
+
+	// remove for good the posts that stayed in the trash longer than the retention
+	go trash.Run(context.Background(), db, store)
+	go router.RunAccountDeletions(context.Background(), db, store)
+	exporter := export.New(db, store)
+	go exporter.Run(context.Background())
+
+	app := fiber.New(fiber.Config{
+		// bodies are streamed and checked by router.LimitBody, so only the file
+		// uploads can go over the default limit
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
 
 	app.Use(logger.New())
+	app.Use(router.LimitBody)
 	app.Use(recover.New())
 	app.Use(cors.New())
 
@@ -74,8 +224,11 @@ func run() error {
 	})
 
 	router.AuthRoutes(app, db)
-	router.UserRoutes(app, db)
-	router.PostRoutes(app, db)
+	router.UserRoutes(app, db, store, exporter)
+	router.PostRoutes(app, db, store, publisher)
+	router.CommentRoutes(app, db)
+	router.TagRoutes(app, db)
+	router.AttachmentRoutes(app, db, store)
 
 	app.Listen(":8080")
 
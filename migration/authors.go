@@ -0,0 +1,35 @@
+package migration
+
+import (
+	"containerized-go-app/models"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SyncAuthorNames copies the current first name of every user on the posts and
+// comments written before renames were propagated. Up to date documents are skipped.
+func SyncAuthorNames(db *mongo.Database) error {
+	ctx := context.Background()
+	cursor, err := db.Collection("User").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		user := models.User{}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		for _, name := range []string{"Post", "Comment"} {
+			_, err := db.Collection(name).UpdateMany(ctx,
+				bson.M{"userId": user.ID.Hex(), "firstName": bson.M{"$ne": user.FirstName}},
+				bson.M{"$set": bson.M{"firstName": user.FirstName}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"containerized-go-app/markdown"
+	"containerized-go-app/models"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// post as it was stored when the comments were embedded in it
+type legacyPost struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Comments []models.Comment   `bson:"comments"`
+}
+
+// MigrateComments moves the comments embedded in the posts to the Comment collection.
+// Comments are upserted by id, so running it again after a failure is safe.
+func MigrateComments(db *mongo.Database) error {
+	ctx := context.Background()
+	postCollection := db.Collection("Post")
+	commentCollection := db.Collection("Comment")
+
+	cursor, err := postCollection.Find(ctx, bson.M{"comments": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		post := legacyPost{}
+		if err := cursor.Decode(&post); err != nil {
+			return err
+		}
+
+		comments := threadLegacyComments(post)
+		count := 0
+		for _, comment := range comments {
+			_, err := commentCollection.UpdateOne(ctx,
+				bson.M{"id": comment.ID},
+				bson.M{"$setOnInsert": comment},
+				options.Update().SetUpsert(true))
+			if err != nil {
+				return err
+			}
+			if !comment.Deleted {
+				count++
+			}
+		}
+
+		// the post is only cleaned once all of its comments are copied
+		_, err = postCollection.UpdateOne(ctx,
+			bson.M{"_id": post.ID},
+			bson.M{"$set": bson.M{"commentCount": count}, "$unset": bson.M{"comments": ""}})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// set the post and root of each comment, missing parents become deleted comments
+func threadLegacyComments(post legacyPost) []models.Comment {
+	comments := []models.Comment{}
+	byId := map[string]models.Comment{}
+	for _, comment := range post.Comments {
+		comment.PostId = post.ID.Hex()
+		comment.ContentHtml = markdown.Render(comment.Content)
+		if comment.ID == "" {
+			comment.ID = primitive.NewObjectID().Hex()
+		}
+		comments = append(comments, comment)
+	}
+	for _, comment := range comments {
+		byId[comment.ID] = comment
+	}
+
+	tombstones := []models.Comment{}
+	for _, comment := range comments {
+		if _, ok := byId[comment.ParentId]; comment.ParentId != "" && !ok {
+			tombstone := models.Comment{
+				CreatedAt:   comment.CreatedAt,
+				ID:          comment.ParentId,
+				PostId:      comment.PostId,
+				Content:     models.DeletedContent,
+				ContentHtml: markdown.Render(models.DeletedContent),
+				Deleted:     true,
+			}
+			tombstones = append(tombstones, tombstone)
+			byId[tombstone.ID] = tombstone
+		}
+	}
+	comments = append(tombstones, comments...)
+
+	for i := range comments {
+		comments[i].RootId = rootOf(byId, comments[i])
+	}
+	return comments
+}
+
+func rootOf(byId map[string]models.Comment, comment models.Comment) string {
+	seen := map[string]bool{}
+	for comment.ParentId != "" && !seen[comment.ID] {
+		seen[comment.ID] = true
+		parent, ok := byId[comment.ParentId]
+		if !ok {
+			break
+		}
+		comment = parent
+	}
+	return comment.ID
+}
@@ -0,0 +1,45 @@
+package migration
+
+import (
+	"containerized-go-app/markdown"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BackfillContentHtml renders the posts and comments written before their
+// markdown was rendered on write. Documents that already have it are skipped.
+func BackfillContentHtml(db *mongo.Database) error {
+	for _, name := range []string{"Post", "Comment"} {
+		if err := renderCollection(db.Collection(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderCollection(collection *mongo.Collection) error {
+	ctx := context.Background()
+	cursor, err := collection.Find(ctx, bson.M{"contentHtml": bson.M{"$exists": false}, "content": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document struct {
+			ID      interface{} `bson:"_id"`
+			Content string      `bson:"content"`
+		}
+		if err := cursor.Decode(&document); err != nil {
+			return err
+		}
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": document.ID},
+			bson.M{"$set": bson.M{"contentHtml": markdown.Render(document.Content)}})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
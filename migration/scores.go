@@ -0,0 +1,23 @@
+package migration
+
+import (
+	"containerized-go-app/rank"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BackfillPostScores computes the score and hot score of the posts created
+// before they were stored. Posts that already have them are left untouched.
+func BackfillPostScores(db *mongo.Database) error {
+	_, err := db.Collection("Post").UpdateMany(context.Background(),
+		bson.M{"hotScore": bson.M{"$exists": false}},
+		bson.A{
+			bson.M{"$set": bson.M{"score": bson.M{"$subtract": bson.A{
+				bson.M{"$size": bson.M{"$ifNull": bson.A{"$upVotes", bson.A{}}}},
+				bson.M{"$size": bson.M{"$ifNull": bson.A{"$downVotes", bson.A{}}}},
+			}}}},
+			bson.M{"$set": bson.M{"hotScore": rank.HotExpression()}},
+		})
+	return err
+}
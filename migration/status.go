@@ -0,0 +1,17 @@
+package migration
+
+import (
+	"containerized-go-app/models"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BackfillPostStatus marks the posts created before drafts existed as
+// published, the feeds only show published posts.
+func BackfillPostStatus(db *mongo.Database) error {
+	_, err := db.Collection("Post").UpdateMany(context.Background(),
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": models.PostStatusPublished}})
+	return err
+}
@@ -2,33 +2,239 @@ package models
 
 import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
 	"time"
 )
 
 type User struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	CreatedAt  time.Time          `bson:"createdAt,omitempty"`
-	Email      string             `bson:"email,omitempty"`
-	FirstName  string             `bson:"firstName,omitempty"`
-	LastName   string             `bson:"lastName,omitempty"`
-	Password   string             `bson:"password,omitempty"`
-	LastUpVote time.Time          `bson:"lastUpVote,omitempty"`
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt,omitempty"`
+	Email         string             `bson:"email,omitempty"`
+	FirstName     string             `bson:"firstName,omitempty"`
+	LastName      string             `bson:"lastName,omitempty"`
+	Password      string             `bson:"password,omitempty"`
+	LastUpVote    time.Time          `bson:"lastUpVote,omitempty"`
+	Role          string             `bson:"role,omitempty"`
+	PublicProfile bool               `bson:"publicProfile,omitempty"`
+	Avatar        *Avatar            `bson:"avatar,omitempty"`
+	DeactivatedAt *time.Time         `bson:"deactivatedAt,omitempty"`
+	Deletion      *AccountDeletion   `bson:"deletion,omitempty"`
 }
 
+// Avatar is the picture uploaded by a user, it is kept in the storage at each
+// of the avatar sizes. Users without one get an identicon.
+type Avatar struct {
+	ID          string `bson:"id"`
+	ContentType string `bson:"contentType"`
+}
+
+// Key is the storage key of the avatar at a size
+func (a Avatar) Key(size int) string {
+	return "avatar_" + a.ID + "_" + strconv.Itoa(size)
+}
+
+// AvatarUrl is where the avatar of a user is served, it does not change when
+// the avatar does. The posts and comments of deleted accounts have none.
+func AvatarUrl(userId string) string {
+	if userId == "" {
+		return ""
+	}
+	return "/user/" + userId + "/avatar"
+}
+
+// AccountDeletion is set on a user whose account is being deleted, it is kept
+// until every step of the deletion is done so a failed deletion can be resumed
+type AccountDeletion struct {
+	StartedAt time.Time `bson:"startedAt"`
+	Policy    string    `bson:"policy"`
+	// the posts the user commented, their comment count is recomputed at the end
+	PostIds []string `bson:"postIds"`
+}
+
+// what happens to the posts and comments of a deleted account
+const (
+	DeletionPolicyAnonymize = "anonymize"
+	DeletionPolicyDelete    = "delete"
+)
+
+// RoleModerator is the role of the users allowed to moderate other users content
+const RoleModerator = "moderator"
+
 type Post struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt,omitempty"`
+	UserId       string             `json:"userId" bson:"userId,omitempty"`
+	FirstName    string             `json:"firstName" bson:"firstName,omitempty"`
+	Title        string             `json:"title" bson:"title,omitempty"`
+	Content      string             `json:"content" bson:"content,omitempty"`
+	ContentHtml  string             `json:"contentHtml" bson:"contentHtml,omitempty"`
+	Tags         []string           `json:"tags" bson:"tags,omitempty"`
+	CommentCount int                `json:"commentCount" bson:"commentCount,omitempty"`
+	UpVotes      []string           `json:"upVotes" bson:"upVotes,omitempty"`
+	DownVotes    []string           `json:"downVotes" bson:"downVotes,omitempty"`
+	Score        int                `json:"score" bson:"score"`
+	HotScore     float64            `json:"-" bson:"hotScore"`
+	EditedAt     *time.Time         `json:"editedAt" bson:"editedAt,omitempty"`
+	Attachments  []Attachment       `json:"attachments" bson:"attachments,omitempty"`
+	Status       string             `json:"status" bson:"status,omitempty"`
+	PublishAt    *time.Time         `json:"publishAt,omitempty" bson:"publishAt,omitempty"`
+	DeletedAt    *time.Time         `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	Pinned       bool               `json:"pinned" bson:"pinned,omitempty"`
+	Locked       bool               `json:"locked" bson:"locked,omitempty"`
+	AuthorHidden bool               `json:"-" bson:"authorHidden,omitempty"`
+	AvatarUrl    string             `json:"avatarUrl,omitempty" bson:"-"`
+	Poll         *Poll              `json:"-" bson:"poll,omitempty"`
+	Bookmarked   bool               `json:"bookmarked" bson:"-"`
+}
+
+// Poll is asked in a post, each user casts a single ballot. The results are only
+// shown to the users who voted or once the poll is closed, so it is never
+// serialized as is.
+type Poll struct {
+	Options  []PollOption `bson:"options"`
+	Multiple bool         `bson:"multiple,omitempty"`
+	ClosesAt *time.Time   `bson:"closesAt,omitempty"`
+	Ballots  []PollBallot `bson:"ballots"`
+}
+
+// PollOption is a choice of a poll, its id is its position in the poll
+type PollOption struct {
+	ID    int    `bson:"id"`
+	Text  string `bson:"text"`
+	Votes int    `bson:"votes"`
+}
+
+// PollBallot holds the choices of a user in a poll
+type PollBallot struct {
+	UserId  string `bson:"userId"`
+	Choices []int  `bson:"choices"`
+}
+
+// a post is only shown to other users once it is published, a scheduled post
+// is published at its publishAt date
+const (
+	PostStatusDraft     = "draft"
+	PostStatusScheduled = "scheduled"
+	PostStatusPublished = "published"
+)
+
+// Attachment is a file uploaded on a post, the file itself is kept in the storage
+type Attachment struct {
+	ID          string    `json:"id" bson:"id,omitempty"`
+	UserId      string    `json:"userId" bson:"userId,omitempty"`
+	Name        string    `json:"name" bson:"name,omitempty"`
+	ContentType string    `json:"contentType" bson:"contentType,omitempty"`
+	Size        int64     `json:"size" bson:"size,omitempty"`
+	Url         string    `json:"url" bson:"url,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt,omitempty"`
+	Width       int       `json:"width,omitempty" bson:"width,omitempty"`
+	Height      int       `json:"height,omitempty" bson:"height,omitempty"`
+	// resized copies of an image by size name (thumb, medium)
+	Variants map[string]AttachmentVariant `json:"variants,omitempty" bson:"variants,omitempty"`
+}
+
+// VariantKey is the storage key of a resized copy of an image attachment
+func (a Attachment) VariantKey(size string) string {
+	return a.ID + "_" + size
+}
+
+// Keys are the storage keys of the file of an attachment and of its variants
+func (a Attachment) Keys() []string {
+	keys := []string{a.ID}
+	for size := range a.Variants {
+		keys = append(keys, a.VariantKey(size))
+	}
+	return keys
+}
+
+// AttachmentVariant is a resized copy of an image attachment
+type AttachmentVariant struct {
+	ContentType string `json:"contentType" bson:"contentType,omitempty"`
+	Size        int64  `json:"size" bson:"size,omitempty"`
+	Url         string `json:"url" bson:"url,omitempty"`
+	Width       int    `json:"width" bson:"width,omitempty"`
+	Height      int    `json:"height" bson:"height,omitempty"`
+}
+
+// Bookmark is a post saved by a user to read it later
+type Bookmark struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserId    string             `bson:"userId"`
+	PostId    string             `bson:"postId"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// Export is a copy of the data of a user, the archive is built in the background
+// and kept in the storage under Key until ExpiresAt
+type Export struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId    string             `json:"-" bson:"userId"`
+	Status    string             `json:"status" bson:"status"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	// set while a worker builds the archive, a worker that died is replaced after a while
+	StartedAt *time.Time `json:"-" bson:"startedAt,omitempty"`
+	ReadyAt   *time.Time `json:"readyAt,omitempty" bson:"readyAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	Size      int64      `json:"size,omitempty" bson:"size,omitempty"`
+}
+
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+)
+
+// Key is the storage key of the archive
+func (e Export) Key() string {
+	return "export_" + e.ID.Hex()
+}
+
+// Notification tells a user something happened, like an export being ready
+type Notification struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId    string             `json:"-" bson:"userId"`
+	Type      string             `json:"type" bson:"type"`
+	ExportId  string             `json:"exportId,omitempty" bson:"exportId,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+const NotificationExportReady = "export_ready"
+
+// PostRevision is a previous version of a post, saved each time it is edited
+type PostRevision struct {
 	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
-	CreatedAt time.Time          `json:"createdAt" bson:"createdAt,omitempty"`
-	UserId    string             `json:"userId" bson:"userId,omitempty"`
-	FirstName string             `json:"firstName" bson:"firstName,omitempty"`
+	PostId    string             `json:"postId" bson:"postId,omitempty"`
 	Title     string             `json:"title" bson:"title,omitempty"`
 	Content   string             `json:"content" bson:"content,omitempty"`
-	Comments  []Comment          `json:"comments" bson:"comments,omitempty"`
-	UpVotes   []string           `json:"upVotes" bson:"upVotes,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt,omitempty"`
+	RevisedAt time.Time          `json:"revisedAt" bson:"revisedAt,omitempty"`
 }
 
 type Comment struct {
-	CreatedAt time.Time `json:"createdAt" bson:"createdAt,omitempty"`
-	ID        string    `json:"id" bson:"id,omitempty"`
-	FirstName string    `json:"firstName" bson:"firstName,omitempty"`
-	Content   string    `json:"content" bson:"content,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt,omitempty"`
+	ID          string    `json:"id" bson:"id,omitempty"`
+	PostId      string    `json:"postId" bson:"postId,omitempty"`
+	ParentId    string    `json:"parentId" bson:"parentId,omitempty"`
+	RootId      string    `json:"rootId" bson:"rootId,omitempty"`
+	UserId      string    `json:"userId" bson:"userId,omitempty"`
+	FirstName   string    `json:"firstName" bson:"firstName,omitempty"`
+	Content     string    `json:"content" bson:"content,omitempty"`
+	ContentHtml string    `json:"contentHtml" bson:"contentHtml,omitempty"`
+	Deleted     bool      `json:"deleted" bson:"deleted,omitempty"`
+	AvatarUrl   string    `json:"avatarUrl,omitempty" bson:"-"`
+}
+
+// DeletedContent replaces the content of a deleted comment
+const DeletedContent = "[deleted]"
+
+// DeletedAuthor replaces the name of the author of a deleted account
+const DeletedAuthor = "[deleted]"
+
+// CommentNode is a comment placed in its thread, it is only built for responses
+type CommentNode struct {
+	Comment
+	Depth   int            `json:"depth"`
+	Replies []*CommentNode `json:"replies,omitempty"`
+	// set on a thread when only its first replies were sent
+	HasMoreReplies bool   `json:"hasMoreReplies,omitempty"`
+	RepliesCursor  string `json:"repliesCursor,omitempty"`
 }
@@ -0,0 +1,39 @@
+package rank
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"math"
+	"time"
+)
+
+// a post needs 10 times more votes to stay as hot as a post 12.5 hours younger
+const (
+	epoch    = 1134028003
+	halfLife = 45000
+	minVotes = 1
+)
+
+// Hot ranks a post from its score and age, the same way reddit does. It only
+// depends on the post itself so it can be stored and indexed.
+func Hot(score int, createdAt time.Time) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(score)), minVotes))
+	sign := 0.0
+	if score > 0 {
+		sign = 1
+	} else if score < 0 {
+		sign = -1
+	}
+	return sign*order + float64(createdAt.Unix()-epoch)/halfLife
+}
+
+// HotExpression computes Hot from the score and createdAt fields of a post
+// inside an update pipeline
+func HotExpression() bson.M {
+	order := bson.M{"$log10": bson.M{"$max": bson.A{bson.M{"$abs": "$score"}, minVotes}}}
+	sign := bson.M{"$cmp": bson.A{"$score", 0}}
+	seconds := bson.M{"$divide": bson.A{bson.M{"$toLong": "$createdAt"}, 1000}}
+	return bson.M{"$add": bson.A{
+		bson.M{"$multiply": bson.A{sign, order}},
+		bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{seconds, epoch}}, halfLife}},
+	}}
+}
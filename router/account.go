@@ -0,0 +1,232 @@
+package router
+
+import (
+	"containerized-go-app/export"
+	"containerized-go-app/markdown"
+	"containerized-go-app/models"
+	"containerized-go-app/storage"
+	"containerized-go-app/trash"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"os"
+	"time"
+)
+
+// how often the unfinished account deletions are resumed
+const accountDeletionInterval = time.Hour
+
+// deletionPolicy is what happens to the published posts and the comments of a
+// deleted account, from the env ACCOUNT_DELETION_POLICY
+func deletionPolicy() string {
+	if os.Getenv("ACCOUNT_DELETION_POLICY") == models.DeletionPolicyDelete {
+		return models.DeletionPolicyDelete
+	}
+	return models.DeletionPolicyAnonymize
+}
+
+// start the deletion of an account if it matches the filter, from then on its
+// tokens are refused
+func startAccountDeletion(db *mongo.Database, userId primitive.ObjectID, filter bson.M) (*models.User, error) {
+	deletion := models.AccountDeletion{StartedAt: time.Now(), Policy: deletionPolicy(), PostIds: []string{}}
+
+	// the comment counts to fix are read before any comment changes, so a
+	// resumed deletion still knows them
+	if deletion.Policy == models.DeletionPolicyDelete {
+		postIds, err := db.Collection("Comment").Distinct(context.Background(), "postId",
+			bson.M{"userId": userId.Hex(), "deleted": bson.M{"$ne": true}})
+		if err != nil {
+			return nil, err
+		}
+		for _, postId := range postIds {
+			if id, ok := postId.(string); ok {
+				deletion.PostIds = append(deletion.PostIds, id)
+			}
+		}
+	}
+
+	user := models.User{}
+	filter["_id"] = userId
+	filter["deletion"] = nil
+	_, err := db.Collection("User").UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"deletion": deletion}})
+	if err != nil {
+		return nil, err
+	}
+	// read it back, the deletion may have been started by another request
+	err = db.Collection("User").FindOne(context.Background(),
+		bson.M{"_id": userId, "deletion": bson.M{"$exists": true}}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// deleteAccount removes a user whose deletion was started along with its
+// content. Every step can be run again, the user goes last so a failed
+// deletion is found and resumed by RunAccountDeletions.
+func deleteAccount(ctx context.Context, db *mongo.Database, store storage.Storage, user models.User) error {
+	userId := user.ID.Hex()
+	postCollection := db.Collection("Post")
+	commentCollection := db.Collection("Comment")
+
+	// the posts nobody else can see are removed whatever the policy
+	filter := bson.M{"userId": userId}
+	if user.Deletion.Policy != models.DeletionPolicyDelete {
+		filter["$or"] = bson.A{
+			bson.M{"status": bson.M{"$ne": models.PostStatusPublished}},
+			bson.M{"deletedAt": bson.M{"$ne": nil}},
+		}
+	}
+	cursor, err := postCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	posts := []models.Post{}
+	if err = cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	for _, post := range posts {
+		if err = trash.DeletePost(ctx, db, store, post); err != nil {
+			return err
+		}
+	}
+
+	// the posts left are kept without their author
+	_, err = postCollection.UpdateMany(ctx,
+		bson.M{"userId": userId, "attachments.0": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"attachments.$[].userId": ""}})
+	if err != nil {
+		return err
+	}
+	_, err = postCollection.UpdateMany(ctx,
+		bson.M{"userId": userId},
+		bson.M{"$set": bson.M{"firstName": models.DeletedAuthor}, "$unset": bson.M{"userId": "", "authorHidden": ""}})
+	if err != nil {
+		return err
+	}
+
+	// the comments are anonymized or left as tombstones so the threads stay whole
+	update := bson.M{"$set": bson.M{"firstName": models.DeletedAuthor}, "$unset": bson.M{"userId": ""}}
+	if user.Deletion.Policy == models.DeletionPolicyDelete {
+		update = bson.M{
+			"$set":   bson.M{"deleted": true, "content": models.DeletedContent, "contentHtml": markdown.Render(models.DeletedContent)},
+			"$unset": bson.M{"firstName": "", "userId": ""},
+		}
+	}
+	if _, err = commentCollection.UpdateMany(ctx, bson.M{"userId": userId}, update); err != nil {
+		return err
+	}
+	for _, postId := range user.Deletion.PostIds {
+		objId, _ := primitive.ObjectIDFromHex(postId)
+		count, err := commentCollection.CountDocuments(ctx, bson.M{"postId": postId, "deleted": bson.M{"$ne": true}})
+		if err != nil {
+			return err
+		}
+		if _, err = postCollection.UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": bson.M{"commentCount": count}}); err != nil {
+			return err
+		}
+	}
+
+	// take back the votes of the user on the other posts
+	_, err = postCollection.UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"upVotes": userId}, bson.M{"downVotes": userId}}},
+		voteUpdate(userId, VoteClear))
+	if err != nil {
+		return err
+	}
+	if err = removeBallots(ctx, postCollection, userId); err != nil {
+		return err
+	}
+
+	if _, err = db.Collection("Bookmark").DeleteMany(ctx, bson.M{"userId": userId}); err != nil {
+		return err
+	}
+	if err = export.DeleteAll(ctx, db, store, userId); err != nil {
+		return err
+	}
+	if user.Avatar != nil {
+		if err = deleteAvatarFiles(store, *user.Avatar); err != nil {
+			return err
+		}
+	}
+	_, err = db.Collection("User").DeleteOne(ctx, bson.M{"_id": user.ID})
+	return err
+}
+
+// remove the poll ballots of a user, each ballot goes with its tallies
+func removeBallots(ctx context.Context, postCollection *mongo.Collection, userId string) error {
+	cursor, err := postCollection.Find(ctx, bson.M{"poll.ballots.userId": userId})
+	if err != nil {
+		return err
+	}
+	posts := []models.Post{}
+	if err = cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	for _, post := range posts {
+		votes := bson.M{}
+		for _, ballot := range post.Poll.Ballots {
+			if ballot.UserId != userId {
+				continue
+			}
+			for _, choice := range ballot.Choices {
+				votes[fmt.Sprintf("poll.options.%d.votes", choice)] = -1
+			}
+		}
+		update := bson.M{"$pull": bson.M{"poll.ballots": bson.M{"userId": userId}}}
+		if len(votes) > 0 {
+			update["$inc"] = votes
+		}
+		_, err = postCollection.UpdateOne(ctx, bson.M{"_id": post.ID, "poll.ballots.userId": userId}, update)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunAccountDeletions starts the deletion of the accounts deactivated for too
+// long and resumes the deletions that failed part way, now and then every
+// accountDeletionInterval, until ctx is done. The posts of the deactivated
+// users are hidden again on the way.
+func RunAccountDeletions(ctx context.Context, db *mongo.Database, store storage.Storage) {
+	ticker := time.NewTicker(accountDeletionInterval)
+	defer ticker.Stop()
+	for {
+		if err := resumeAccountDeletions(ctx, db, store); err != nil {
+			log.Println("account deletion:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func resumeAccountDeletions(ctx context.Context, db *mongo.Database, store storage.Storage) error {
+	if err := syncHiddenAuthors(ctx, db); err != nil {
+		return err
+	}
+	if err := purgeDeactivatedUsers(ctx, db); err != nil {
+		return err
+	}
+
+	cursor, err := db.Collection("User").Find(ctx, bson.M{"deletion": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err = deleteAccount(ctx, db, store, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
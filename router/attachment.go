@@ -0,0 +1,513 @@
+package router
+
+import (
+	"bytes"
+	"containerized-go-app/imaging"
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"containerized-go-app/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxUploadSize is the biggest file accepted by the upload routes
+	MaxUploadSize     = 10 << 20
+	maxAttachments    = 10
+	maxAttachmentName = 255
+)
+
+// only these types are accepted, they are sniffed from the content and not
+// taken from the file name or the request headers
+var allowedContentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+func AttachmentRoutes(app *fiber.App, db *mongo.Database, store storage.Storage) {
+	attachment := app.Group("/attachment", func(c *fiber.Ctx) error {
+		return c.Next()
+	})
+	GetAttachment(db, store, attachment)
+	DeleteAttachment(db, store, attachment)
+}
+
+// LimitBody refuses the requests with a body over the default limit of fiber,
+// or over MaxUploadSize and its multipart envelope for a file upload. Bodies
+// are streamed so it is checked from the length before anything is read, a
+// chunked body has no length and is refused.
+func LimitBody(c *fiber.Ctx) error {
+	length := c.Request().Header.ContentLength()
+	if length == -1 {
+		c.Context().SetConnectionClose()
+		return c.Status(http.StatusLengthRequired).JSON(fiber.Map{
+			"ok":    false,
+			"error": "Length Required",
+		})
+	}
+	limit := fiber.DefaultBodyLimit
+	if strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEMultipartForm) {
+		limit = MaxUploadSize + 1<<20
+	}
+	if length > limit {
+		// the body is left unread, the connection cannot be used again
+		c.Context().SetConnectionClose()
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"ok":    false,
+			"error": "Request Entity Too Large",
+		})
+	}
+	return c.Next()
+}
+
+// sniff the type of a file from its first bytes
+func sniffContentType(head []byte) string {
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return contentType
+}
+
+// parse a single "bytes=start-end" range, multiple ranges are served as the whole file
+func parseRange(header string, size int64) (int64, int64, bool, error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size - 1, false, nil
+	}
+	startValue, endValue, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, fmt.Errorf("invalid range")
+	}
+
+	// "-n" is the last n bytes
+	if startValue == "" {
+		suffix, err := strconv.ParseInt(endValue, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range")
+		}
+		return max(size-suffix, 0), size - 1, true, nil
+	}
+
+	start, err := strconv.ParseInt(startValue, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, fmt.Errorf("invalid range")
+	}
+	end := size - 1
+	if endValue != "" {
+		end, err = strconv.ParseInt(endValue, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, fmt.Errorf("invalid range")
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true, nil
+}
+
+// closes the stored file once fiber has sent the body
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// clean and resize an uploaded image then store it with its variants,
+// the returned status goes with the error
+func saveImage(store storage.Storage, attachment *models.Attachment, r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return http.StatusBadRequest, errors.New("Bad Request")
+	}
+	result, err := imaging.Process(data, attachment.ContentType)
+	if err == imaging.ErrTooLarge {
+		return http.StatusRequestEntityTooLarge, errors.New("Image too large")
+	}
+	if err != nil {
+		return http.StatusUnprocessableEntity, errors.New("Invalid image")
+	}
+
+	if err = store.Save(attachment.ID, bytes.NewReader(result.Original.Data)); err != nil {
+		return http.StatusInternalServerError, errors.New("Internal Server Error")
+	}
+	// a WebP image is stored as PNG
+	attachment.ContentType = result.Original.ContentType
+	attachment.Size = int64(len(result.Original.Data))
+	attachment.Width = result.Original.Width
+	attachment.Height = result.Original.Height
+	attachment.Variants = map[string]models.AttachmentVariant{}
+	for _, size := range imaging.Sizes {
+		variant := result.Variants[size.Name]
+		attachment.Variants[size.Name] = models.AttachmentVariant{
+			ContentType: variant.ContentType,
+			Size:        int64(len(variant.Data)),
+			Url:         "/attachment/" + attachment.ID + "/" + size.Name,
+			Width:       variant.Width,
+			Height:      variant.Height,
+		}
+		if err = store.Save(attachment.VariantKey(size.Name), bytes.NewReader(variant.Data)); err != nil {
+			_ = deleteAttachmentFiles(store, *attachment)
+			return http.StatusInternalServerError, errors.New("Internal Server Error")
+		}
+	}
+	return http.StatusCreated, nil
+}
+
+// remove the file of an attachment and the files of its variants
+func deleteAttachmentFiles(store storage.Storage, attachment models.Attachment) error {
+	for _, key := range attachment.Keys() {
+		if err := store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UploadAttachment(db *mongo.Database, store storage.Storage, post fiber.Router) {
+	post.Post("/:id/attachments", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// get post from db
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// check if the post belongs to the user
+		if post.UserId != UserId {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Forbidden",
+			})
+		}
+
+		// get the uploaded file
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+		if fileHeader.Size > MaxUploadSize {
+			return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"ok":    false,
+				"error": "File too large",
+			})
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+		defer file.Close()
+
+		// check the type of the file from its content
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+		head = head[:n]
+		contentType := sniffContentType(head)
+		if !allowedContentTypes[contentType] {
+			return c.Status(http.StatusUnsupportedMediaType).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unsupported file type",
+			})
+		}
+
+		name := filepath.Base(fileHeader.Filename)
+		if len(name) > maxAttachmentName {
+			name = name[:maxAttachmentName]
+		}
+		id := primitive.NewObjectID().Hex()
+		attachment := models.Attachment{
+			ID:          id,
+			UserId:      UserId,
+			Name:        name,
+			ContentType: contentType,
+			Size:        fileHeader.Size,
+			Url:         "/attachment/" + id,
+			CreatedAt:   time.Now(),
+		}
+
+		// save the file in the storage, images are cleaned of their metadata and resized
+		if imaging.Supported(contentType) {
+			status, err := saveImage(store, &attachment, io.MultiReader(bytes.NewReader(head), file))
+			if err != nil {
+				return c.Status(status).JSON(fiber.Map{
+					"ok":    false,
+					"error": err.Error(),
+				})
+			}
+		} else if err = store.Save(id, io.MultiReader(bytes.NewReader(head), file)); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// attach it to the post, the filter keeps the number of attachments bounded
+		res, err := postCollection.UpdateOne(context.Background(),
+			bson.M{"_id": objId, "userId": UserId, "deletedAt": nil, fmt.Sprintf("attachments.%d", maxAttachments-1): bson.M{"$exists": false}},
+			bson.M{"$push": bson.M{"attachments": attachment}})
+		if err != nil || res.MatchedCount == 0 {
+			_ = deleteAttachmentFiles(store, attachment)
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		if res.MatchedCount == 0 {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Too many attachments",
+			})
+		}
+
+		return c.Status(http.StatusCreated).JSON(fiber.Map{
+			"ok":   true,
+			"data": attachment,
+		})
+	})
+}
+
+func GetAttachment(db *mongo.Database, store storage.Storage, attachment fiber.Router) {
+	attachment.Get("/:id", func(c *fiber.Ctx) error {
+		// the attachments of published posts are public so they can be used in <img> tags
+		existingAttachment, published, err := findAttachment(db, c.Params("id"), optionalUserID(c, db))
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Attachment not found",
+			})
+		}
+		return sendAttachmentFile(c, store, existingAttachment, existingAttachment.ID, existingAttachment.ContentType, published)
+	})
+
+	attachment.Get("/:id/:size", func(c *fiber.Ctx) error {
+		existingAttachment, published, err := findAttachment(db, c.Params("id"), optionalUserID(c, db))
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Attachment not found",
+			})
+		}
+		variant, ok := existingAttachment.Variants[c.Params("size")]
+		if !ok {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Variant not found",
+			})
+		}
+		return sendAttachmentFile(c, store, existingAttachment, existingAttachment.VariantKey(c.Params("size")), variant.ContentType, published)
+	})
+}
+
+// the user of the token when there is a valid one, for the routes that also
+// work without it
+func optionalUserID(c *fiber.Ctx, db *mongo.Database) string {
+	if len(c.Get("Authorization")) <= len("Bearer ") {
+		return ""
+	}
+	userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// get an attachment from the post it belongs to and tell if the post is
+// published, the attachments of a draft or a scheduled post are only found by
+// its author
+func findAttachment(db *mongo.Database, id string, userId string) (models.Attachment, bool, error) {
+	filter := bson.M{"attachments.id": id, "deletedAt": nil, "status": models.PostStatusPublished}
+	if userId != "" {
+		delete(filter, "status")
+		filter["$or"] = bson.A{bson.M{"status": models.PostStatusPublished}, bson.M{"userId": userId}}
+	}
+	post := models.Post{}
+	err := db.Collection("Post").FindOne(context.Background(),
+		filter,
+		options.FindOne().SetProjection(bson.M{"attachments.$": 1, "status": 1})).Decode(&post)
+	if err != nil {
+		return models.Attachment{}, false, err
+	}
+	if len(post.Attachments) == 0 {
+		return models.Attachment{}, false, mongo.ErrNoDocuments
+	}
+	return post.Attachments[0], post.Status == models.PostStatusPublished, nil
+}
+
+// the etag of a stored file, the first bytes of the hash of its key so the
+// keys of the storage are never given out
+func storageEtag(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// send a stored file with cache headers and support for a single range, only
+// the files of published posts can be kept by shared caches
+func sendAttachmentFile(c *fiber.Ctx, store storage.Storage, attachment models.Attachment, key string, contentType string, public bool) error {
+	// the content of a stored file never changes, its key is enough to cache it
+	etag := storageEtag(key)
+	if public {
+		c.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Set("Cache-Control", "private, no-cache")
+	}
+	c.Set("ETag", etag)
+	c.Set("Last-Modified", attachment.CreatedAt.UTC().Format(http.TimeFormat))
+	c.Set("Accept-Ranges", "bytes")
+	c.Set("X-Content-Type-Options", "nosniff")
+	if c.Get("If-None-Match") == etag {
+		return c.SendStatus(http.StatusNotModified)
+	}
+
+	file, err := store.Open(key)
+	if err == storage.ErrNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"ok":    false,
+			"error": "Attachment not found",
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"ok":    false,
+			"error": "Internal Server Error",
+		})
+	}
+
+	// images are shown in the page, other files are downloaded
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	c.Set("Content-Type", contentType)
+	c.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+
+	size := file.Size()
+	start, end, partial, err := parseRange(c.Get("Range"), size)
+	if err != nil {
+		file.Close()
+		c.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return c.SendStatus(http.StatusRequestedRangeNotSatisfiable)
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		file.Close()
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"ok":    false,
+			"error": "Internal Server Error",
+		})
+	}
+
+	length := end - start + 1
+	if partial {
+		c.Status(http.StatusPartialContent)
+		c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+	return c.SendStream(limitedFile{Reader: io.LimitReader(file, length), Closer: file}, int(length))
+}
+
+func DeleteAttachment(db *mongo.Database, store storage.Storage, attachment fiber.Router) {
+	attachment.Delete("/:id", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get the post of the attachment
+		postCollection := db.Collection("Post")
+		id := c.Params("id")
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(),
+			bson.M{"attachments.id": id, "deletedAt": nil},
+			options.FindOne().SetProjection(bson.M{"userId": 1, "attachments.$": 1})).Decode(&post)
+		if err != nil || len(post.Attachments) == 0 {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Attachment not found",
+			})
+		}
+
+		// check if the post belongs to the user
+		if post.UserId != UserId {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Forbidden",
+			})
+		}
+
+		// detach it from the post then remove the file
+		_, err = postCollection.UpdateOne(context.Background(),
+			bson.M{"_id": post.ID},
+			bson.M{"$pull": bson.M{"attachments": bson.M{"id": id}}})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		if err = deleteAttachmentFiles(store, post.Attachments[0]); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"id":      id,
+				"removed": true,
+			},
+		})
+	})
+}
@@ -35,7 +35,7 @@ func Login(db *mongo.Database, auth fiber.Router) {
 
 		// get user from db
 		existingUser := models.User{}
-		err := userCollection.FindOne(context.Background(), bson.M{"email": loginRequest.Email}).Decode(&existingUser)
+		err := userCollection.FindOne(context.Background(), bson.M{"email": loginRequest.Email, "deletion": nil}).Decode(&existingUser)
 		if err != nil {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
 				"ok":    false,
@@ -51,6 +51,23 @@ func Login(db *mongo.Database, auth fiber.Router) {
 			})
 		}
 
+		// logging in during the grace period brings a deactivated account back
+		if existingUser.DeactivatedAt != nil {
+			reactivated, err := reactivateUser(db, existingUser.ID)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+			if !reactivated {
+				return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+					"ok":    false,
+					"error": "wrong credentials",
+				})
+			}
+		}
+
 		// Generate JWT token
 		userID := existingUser.ID.Hex()
 		token := jwt.GetToken(userID)
@@ -77,16 +94,27 @@ func Login(db *mongo.Database, auth fiber.Router) {
 
 func Register(db *mongo.Database, auth fiber.Router) {
 	auth.Post("/register", func(c *fiber.Ctx) error {
-		var user models.User
-
-		// parse body, return 400 if invalid
-		if err := c.BodyParser(&user); err != nil || user.Email == "" ||
-			user.Password == "" || user.FirstName == "" || user.LastName == "" {
+		// parse body, return 400 if invalid. Only these fields are read, the rest
+		// of the user (id, role, ...) is set by the server
+		var registerRequest struct {
+			Email     string `json:"email"`
+			Password  string `json:"password"`
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		}
+		if err := c.BodyParser(&registerRequest); err != nil || registerRequest.Email == "" ||
+			registerRequest.Password == "" || registerRequest.FirstName == "" || registerRequest.LastName == "" {
 			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Bad Request",
 			})
 		}
+		user := models.User{
+			Email:     registerRequest.Email,
+			Password:  registerRequest.Password,
+			FirstName: registerRequest.FirstName,
+			LastName:  registerRequest.LastName,
+		}
 
 		// get user collection
 		userCollection := db.Collection("User")
@@ -0,0 +1,252 @@
+package router
+
+import (
+	"bytes"
+	"containerized-go-app/imaging"
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"containerized-go-app/storage"
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// the avatar url does not change with the avatar, caches check it again after a while
+const avatarMaxAge = 3600
+
+// remove the files of an avatar at every size
+func deleteAvatarFiles(store storage.Storage, avatar models.Avatar) error {
+	for _, size := range imaging.AvatarSizes {
+		if err := store.Delete(avatar.Key(size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UploadAvatar(db *mongo.Database, store storage.Storage, user fiber.Router) {
+	user.Put("/me/avatar", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		// get the uploaded picture
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+		if fileHeader.Size > MaxUploadSize {
+			return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"ok":    false,
+				"error": "File too large",
+			})
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
+		// check the type of the picture from its content, then crop and resize it
+		contentType := sniffContentType(data)
+		if !imaging.Supported(contentType) {
+			return c.Status(http.StatusUnsupportedMediaType).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unsupported file type",
+			})
+		}
+		avatars, err := imaging.Avatar(data, contentType)
+		if err == imaging.ErrTooLarge {
+			return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Image too large",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid image",
+			})
+		}
+
+		// every avatar gets new keys so the files of the previous one can be removed after the switch
+		avatar := models.Avatar{ID: primitive.NewObjectID().Hex(), ContentType: avatars[imaging.DefaultAvatarSize].ContentType}
+		for _, size := range imaging.AvatarSizes {
+			if err = store.Save(avatar.Key(size), bytes.NewReader(avatars[size].Data)); err != nil {
+				_ = deleteAvatarFiles(store, avatar)
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+		}
+
+		objId, _ := primitive.ObjectIDFromHex(userID)
+		previous := models.User{}
+		err = db.Collection("User").FindOneAndUpdate(context.Background(),
+			bson.M{"_id": objId},
+			bson.M{"$set": bson.M{"avatar": avatar}},
+			options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previous)
+		if err != nil {
+			_ = deleteAvatarFiles(store, avatar)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		if previous.Avatar != nil {
+			if err = deleteAvatarFiles(store, *previous.Avatar); err != nil {
+				log.Println("avatar:", err)
+			}
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"avatarUrl": models.AvatarUrl(userID),
+			},
+		})
+	})
+}
+
+func DeleteAvatar(db *mongo.Database, store storage.Storage, user fiber.Router) {
+	user.Delete("/me/avatar", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		// the identicon is served again once the avatar is removed
+		objId, _ := primitive.ObjectIDFromHex(userID)
+		previous := models.User{}
+		err = db.Collection("User").FindOneAndUpdate(context.Background(),
+			bson.M{"_id": objId},
+			bson.M{"$unset": bson.M{"avatar": ""}},
+			options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previous)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		if previous.Avatar != nil {
+			if err = deleteAvatarFiles(store, *previous.Avatar); err != nil {
+				log.Println("avatar:", err)
+			}
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"avatarUrl": models.AvatarUrl(userID),
+			},
+		})
+	})
+}
+
+func GetAvatar(db *mongo.Database, store storage.Storage, user fiber.Router) {
+	user.Get("/:id/avatar", func(c *fiber.Ctx) error {
+		// avatars are public so they can be used in <img> tags
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+		size := imaging.DefaultAvatarSize
+		if value := c.Query("size"); value != "" {
+			size, _ = strconv.Atoi(value)
+		}
+		found := false
+		for _, avatarSize := range imaging.AvatarSizes {
+			found = found || avatarSize == size
+		}
+		if !found {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Size not found",
+			})
+		}
+
+		profile := models.User{}
+		err := db.Collection("User").FindOne(context.Background(),
+			bson.M{"_id": objId, "deletion": nil},
+			options.FindOne().SetProjection(bson.M{"avatar": 1})).Decode(&profile)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "User not found",
+			})
+		}
+
+		// the uploaded avatar, or the identicon of the user. The etag is a hash so
+		// the storage key of the avatar is never given out
+		etag := storageEtag(fmt.Sprintf("identicon_%s_%d", objId.Hex(), size))
+		if profile.Avatar != nil {
+			etag = storageEtag(profile.Avatar.Key(size))
+		}
+		c.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", avatarMaxAge))
+		c.Set("ETag", etag)
+		c.Set("X-Content-Type-Options", "nosniff")
+		if c.Get("If-None-Match") == etag {
+			return c.SendStatus(http.StatusNotModified)
+		}
+
+		if profile.Avatar == nil {
+			identicon, err := imaging.Identicon(objId.Hex(), size)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+			c.Set("Content-Type", identicon.ContentType)
+			return c.Send(identicon.Data)
+		}
+
+		file, err := store.Open(profile.Avatar.Key(size))
+		if err == storage.ErrNotFound {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Avatar not found",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		c.Set("Content-Type", profile.Avatar.ContentType)
+		return c.SendStream(file, int(file.Size()))
+	})
+}
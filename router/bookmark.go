@@ -0,0 +1,248 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+)
+
+// get which of the given posts the user saved
+func bookmarkedPosts(db *mongo.Database, userId string, postIds []string) (map[string]bool, error) {
+	bookmarked := map[string]bool{}
+	if len(postIds) == 0 {
+		return bookmarked, nil
+	}
+	cursor, err := db.Collection("Bookmark").Find(context.Background(),
+		bson.M{"userId": userId, "postId": bson.M{"$in": postIds}},
+		options.Find().SetProjection(bson.M{"postId": 1}))
+	if err != nil {
+		return nil, err
+	}
+	bookmarks := []models.Bookmark{}
+	if err = cursor.All(context.Background(), &bookmarks); err != nil {
+		return nil, err
+	}
+	for _, bookmark := range bookmarks {
+		bookmarked[bookmark.PostId] = true
+	}
+	return bookmarked, nil
+}
+
+// set the bookmarked flag of a page of posts for the user
+func markBookmarked(db *mongo.Database, userId string, posts []models.Post) error {
+	postIds := []string{}
+	for _, post := range posts {
+		postIds = append(postIds, post.ID.Hex())
+	}
+	bookmarked, err := bookmarkedPosts(db, userId, postIds)
+	if err != nil {
+		return err
+	}
+	for i, post := range posts {
+		posts[i].Bookmarked = bookmarked[post.ID.Hex()]
+	}
+	return nil
+}
+
+func BookmarkPostById(db *mongo.Database, post fiber.Router) {
+	post.Post("/:id/bookmark", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// only published posts can be saved
+		post := models.Post{}
+		err = db.Collection("Post").FindOne(context.Background(),
+			bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// saving a post twice keeps the first date
+		_, err = db.Collection("Bookmark").UpdateOne(context.Background(),
+			bson.M{"userId": UserId, "postId": post.ID.Hex()},
+			bson.M{"$setOnInsert": bson.M{"createdAt": time.Now()}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"postId":     post.ID.Hex(),
+				"bookmarked": true,
+			},
+		})
+	})
+
+	post.Delete("/:id/bookmark", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		_, err = db.Collection("Bookmark").DeleteOne(context.Background(), bson.M{"userId": UserId, "postId": objId.Hex()})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"postId":     objId.Hex(),
+				"bookmarked": false,
+			},
+		})
+	})
+}
+
+func GetMyBookmarks(db *mongo.Database, user fiber.Router) {
+	user.Get("/me/bookmarks", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		// pages are made of bookmarks, newest saved first
+		match := bson.M{"userId": userID}
+		if cursor := c.Query("cursor"); cursor != "" {
+			createdAt, id, err := decodeCursor(cursor)
+			objId, _ := primitive.ObjectIDFromHex(id)
+			if err != nil || objId.IsZero() {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Invalid cursor",
+				})
+			}
+			match["$or"] = bson.A{
+				bson.M{"createdAt": bson.M{"$lt": createdAt}},
+				bson.M{"createdAt": createdAt, "_id": bson.M{"$lt": objId}},
+			}
+		}
+		limit := pageLimit(c)
+
+		// the bookmarks of posts that are not visible anymore (deleted, in the trash,
+		// of a deactivated user) are left out before the page is cut so pages stay full
+		postMatch := bson.M{
+			"$expr":        bson.M{"$eq": bson.A{"$_id", "$$postId"}},
+			"status":       models.PostStatusPublished,
+			"authorHidden": nil,
+			"deletedAt":    nil,
+		}
+		cursor, err := db.Collection("Bookmark").Aggregate(context.Background(), mongo.Pipeline{
+			{{Key: "$match", Value: match}},
+			{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+			{{Key: "$lookup", Value: bson.M{
+				"from": "Post",
+				"let":  bson.M{"postId": bson.M{"$toObjectId": "$postId"}},
+				"pipeline": bson.A{
+					bson.M{"$match": postMatch},
+				},
+				"as": "post",
+			}}},
+			{{Key: "$unwind", Value: "$post"}},
+			{{Key: "$limit", Value: limit + 1}},
+		})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		bookmarks := []struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			CreatedAt time.Time          `bson:"createdAt"`
+			Post      models.Post        `bson:"post"`
+		}{}
+		if err = cursor.All(context.Background(), &bookmarks); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		nextCursor := ""
+		if len(bookmarks) > limit {
+			bookmarks = bookmarks[:limit]
+			last := bookmarks[len(bookmarks)-1]
+			nextCursor = encodeCursor(last.CreatedAt, last.ID.Hex())
+		}
+
+		// change nil array to empty array
+		data := []fiber.Map{}
+		for _, bookmark := range bookmarks {
+			post := bookmark.Post
+			if post.UpVotes == nil {
+				post.UpVotes = []string{}
+			}
+			if post.DownVotes == nil {
+				post.DownVotes = []string{}
+			}
+			if post.Tags == nil {
+				post.Tags = []string{}
+			}
+			if post.Attachments == nil {
+				post.Attachments = []models.Attachment{}
+			}
+			post.AvatarUrl = models.AvatarUrl(post.UserId)
+			post.Bookmarked = true
+			data = append(data, fiber.Map{
+				"bookmarkedAt": bookmark.CreatedAt,
+				"post":         post,
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":         true,
+			"data":       data,
+			"nextCursor": nextCursor,
+		})
+	})
+}
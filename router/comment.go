@@ -0,0 +1,585 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/markdown"
+	"containerized-go-app/models"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+)
+
+func CommentRoutes(app *fiber.App, db *mongo.Database) {
+	comment := app.Group("/comment", func(c *fiber.Ctx) error {
+		return c.Next()
+	})
+	CreateComment(db, comment)
+	GetComments(db, comment)
+	GetReplies(db, comment)
+	GetCommentById(db, comment)
+	EditComment(db, comment)
+	DeleteComment(db, comment)
+}
+
+func CreateComment(db *mongo.Database, comment fiber.Router) {
+	comment.Post("/:postId", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		userId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get comment request
+		var commentRequest models.Comment
+		if err := c.BodyParser(&commentRequest); err != nil || commentRequest.Content == "" {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("postId"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// a reply must point to a valid comment id, even if it was deleted since
+		if commentRequest.ParentId != "" {
+			if parentId, _ := primitive.ObjectIDFromHex(commentRequest.ParentId); parentId.IsZero() {
+				return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Invalid parent ID",
+				})
+			}
+		}
+
+		// get post from db, only published posts can be commented
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// locked posts can still be read but not commented
+		if post.Locked {
+			return c.Status(http.StatusLocked).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post is locked",
+			})
+		}
+
+		// get user from db
+		userCollection := db.Collection("User")
+		userObjId, _ := primitive.ObjectIDFromHex(userId)
+		user := models.User{}
+		_ = userCollection.FindOne(context.Background(), bson.M{"_id": userObjId}).Decode(&user)
+
+		// create new comment, a root comment is the root of its own thread
+		commentCollection := db.Collection("Comment")
+		newComment := models.Comment{
+			CreatedAt:   time.Now(),
+			ID:          primitive.NewObjectID().Hex(),
+			PostId:      post.ID.Hex(),
+			ParentId:    commentRequest.ParentId,
+			UserId:      userId,
+			FirstName:   user.FirstName,
+			Content:     commentRequest.Content,
+			ContentHtml: markdown.Render(commentRequest.Content),
+		}
+		newComment.RootId = newComment.ID
+
+		// a reply joins the thread of its parent, deleted parents are kept as tombstones
+		if newComment.ParentId != "" {
+			parent := models.Comment{}
+			err = commentCollection.FindOne(context.Background(), bson.M{"postId": newComment.PostId, "id": newComment.ParentId}).Decode(&parent)
+			if err != nil {
+				return c.Status(http.StatusNotFound).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Parent comment not found",
+				})
+			}
+			newComment.RootId = parent.RootId
+		}
+
+		// insert comment to db
+		_, err = commentCollection.InsertOne(context.Background(), newComment)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		_, err = postCollection.UpdateOne(context.Background(), bson.M{"_id": objId}, bson.M{"$inc": bson.M{"commentCount": 1}})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		newComment.AvatarUrl = models.AvatarUrl(newComment.UserId)
+		return c.Status(http.StatusCreated).JSON(fiber.Map{
+			"ok":   true,
+			"data": newComment,
+		})
+	})
+}
+
+// the replies sent with each thread of a page of comments, the next ones are paged
+const maxThreadReplies = 50
+
+func GetComments(db *mongo.Database, comment fiber.Router) {
+	comment.Get("/:postId", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		_, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("postId"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// get post from db
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// pages are made of root comments, oldest first
+		commentCollection := db.Collection("Comment")
+		limit := pageLimit(c)
+		filter := bson.M{"postId": post.ID.Hex(), "parentId": nil}
+		if cursor := c.Query("cursor"); cursor != "" {
+			createdAt, id, err := decodeCursor(cursor)
+			if err != nil {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Invalid cursor",
+				})
+			}
+			filter["$or"] = bson.A{
+				bson.M{"createdAt": bson.M{"$gt": createdAt}},
+				bson.M{"createdAt": createdAt, "id": bson.M{"$gt": id}},
+			}
+		}
+		findOptions := options.Find().
+			SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "id", Value: 1}}).
+			SetLimit(int64(limit + 1))
+		cursor, err := commentCollection.Find(context.Background(), filter, findOptions)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		roots := []models.Comment{}
+		if err = cursor.All(context.Background(), &roots); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		nextCursor := ""
+		if len(roots) > limit {
+			roots = roots[:limit]
+			last := roots[len(roots)-1]
+			nextCursor = encodeCursor(last.CreatedAt, last.ID)
+		}
+
+		// get the first replies of each thread of the page, oldest first so every
+		// reply comes after its parent. The others are read with GetReplies.
+		comments := []models.Comment{}
+		repliesCursors := map[string]string{}
+		for _, root := range roots {
+			comments = append(comments, root)
+			replies, repliesCursor, err := findReplies(commentCollection, root, "", maxThreadReplies)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+			comments = append(comments, replies...)
+			if repliesCursor != "" {
+				repliesCursors[root.ID] = repliesCursor
+			}
+		}
+
+		for i, comment := range comments {
+			comments[i].AvatarUrl = models.AvatarUrl(comment.UserId)
+		}
+
+		// return the threads nested, or flat with the depth of each comment
+		threads := buildCommentTree(comments, commentMaxDepth(c))
+		for _, thread := range threads {
+			if repliesCursor, ok := repliesCursors[thread.ID]; ok {
+				thread.HasMoreReplies = true
+				thread.RepliesCursor = repliesCursor
+			}
+		}
+		if c.Query("format") == "flat" {
+			threads = flattenCommentTree(threads)
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":         true,
+			"data":       threads,
+			"nextCursor": nextCursor,
+		})
+	})
+}
+
+// find a page of the replies of a thread after a cursor, oldest first
+func findReplies(commentCollection *mongo.Collection, root models.Comment, cursor string, limit int) ([]models.Comment, string, error) {
+	filter := bson.M{"postId": root.PostId, "rootId": root.ID, "parentId": bson.M{"$ne": nil}}
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", errInvalidCursor
+		}
+		filter["$or"] = bson.A{
+			bson.M{"createdAt": bson.M{"$gt": createdAt}},
+			bson.M{"createdAt": createdAt, "id": bson.M{"$gt": id}},
+		}
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "id", Value: 1}}).
+		SetLimit(int64(limit + 1))
+	found, err := commentCollection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, "", err
+	}
+	replies := []models.Comment{}
+	if err = found.All(context.Background(), &replies); err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(replies) > limit {
+		replies = replies[:limit]
+		last := replies[len(replies)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return replies, nextCursor, nil
+}
+
+func GetReplies(db *mongo.Database, comment fiber.Router) {
+	comment.Get("/:postId/:id/replies", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		_, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("postId"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// get post from db
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// the replies are paged by thread, from the root comment
+		commentCollection := db.Collection("Comment")
+		root := models.Comment{}
+		err = commentCollection.FindOne(context.Background(), bson.M{"postId": post.ID.Hex(), "id": c.Params("id"), "parentId": nil}).Decode(&root)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Comment not found",
+			})
+		}
+		replies, nextCursor, err := findReplies(commentCollection, root, c.Query("cursor"), pageLimit(c))
+		if err == errInvalidCursor {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid cursor",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		for i, reply := range replies {
+			replies[i].AvatarUrl = models.AvatarUrl(reply.UserId)
+		}
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":         true,
+			"data":       replies,
+			"nextCursor": nextCursor,
+		})
+	})
+}
+
+func GetCommentById(db *mongo.Database, comment fiber.Router) {
+	comment.Get("/:postId/:id", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		_, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("postId"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// get post from db
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// get comment from db
+		commentCollection := db.Collection("Comment")
+		existingComment := models.Comment{}
+		err = commentCollection.FindOne(context.Background(), bson.M{"postId": post.ID.Hex(), "id": c.Params("id")}).Decode(&existingComment)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Comment not found",
+			})
+		}
+
+		existingComment.AvatarUrl = models.AvatarUrl(existingComment.UserId)
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": existingComment,
+		})
+	})
+}
+
+func EditComment(db *mongo.Database, comment fiber.Router) {
+	comment.Put("/:postId/:id", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		userId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get comment request
+		var commentRequest models.Comment
+		if err := c.BodyParser(&commentRequest); err != nil || commentRequest.Content == "" {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("postId"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// get post from db
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// get comment from db, a deleted comment can't be edited
+		commentCollection := db.Collection("Comment")
+		existingComment := models.Comment{}
+		err = commentCollection.FindOne(context.Background(),
+			bson.M{"postId": post.ID.Hex(), "id": c.Params("id"), "deleted": bson.M{"$ne": true}}).Decode(&existingComment)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Comment not found",
+			})
+		}
+
+		// check if the comment belongs to the user
+		if existingComment.UserId != userId {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Forbidden",
+			})
+		}
+
+		// update comment content
+		_, err = commentCollection.UpdateOne(context.Background(),
+			bson.M{"id": existingComment.ID},
+			bson.M{"$set": bson.M{"content": commentRequest.Content, "contentHtml": markdown.Render(commentRequest.Content)}})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		existingComment.Content = commentRequest.Content
+		existingComment.ContentHtml = markdown.Render(commentRequest.Content)
+
+		existingComment.AvatarUrl = models.AvatarUrl(existingComment.UserId)
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": existingComment,
+		})
+	})
+}
+
+func DeleteComment(db *mongo.Database, comment fiber.Router) {
+	comment.Delete("/:postId/:id", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		userId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("postId"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// get post from db
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// get comment from db
+		commentCollection := db.Collection("Comment")
+		existingComment := models.Comment{}
+		err = commentCollection.FindOne(context.Background(),
+			bson.M{"postId": post.ID.Hex(), "id": c.Params("id"), "deleted": bson.M{"$ne": true}}).Decode(&existingComment)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Comment not found",
+			})
+		}
+
+		// get user from db
+		userCollection := db.Collection("User")
+		userObjId, _ := primitive.ObjectIDFromHex(userId)
+		user := models.User{}
+		_ = userCollection.FindOne(context.Background(), bson.M{"_id": userObjId}).Decode(&user)
+
+		// check if the comment belongs to the user, moderators can delete any comment
+		if existingComment.UserId != userId && user.Role != models.RoleModerator {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Forbidden",
+			})
+		}
+
+		// keep the comment as a tombstone so its replies stay in the thread
+		res, err := commentCollection.UpdateOne(context.Background(),
+			bson.M{"id": existingComment.ID, "deleted": bson.M{"$ne": true}},
+			bson.M{
+				"$set":   bson.M{"deleted": true, "content": models.DeletedContent, "contentHtml": markdown.Render(models.DeletedContent)},
+				"$unset": bson.M{"firstName": "", "userId": ""},
+			})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// only count the deletion once if two requests raced
+		if res.ModifiedCount > 0 {
+			_, err = postCollection.UpdateOne(context.Background(), bson.M{"_id": objId}, bson.M{"$inc": bson.M{"commentCount": -1}})
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"id":      existingComment.ID,
+				"removed": true,
+			},
+		})
+	})
+}
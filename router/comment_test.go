@@ -0,0 +1,84 @@
+package router
+
+import (
+	"containerized-go-app/models"
+	"context"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetCommentsCapsReplies(t *testing.T) {
+	db := testDatabase(t)
+	userId, token := testUser(t, db, "Alice")
+	postId := testPost(t, db, userId, "Alice")
+
+	// a thread with more replies than a page sends
+	const replies = maxThreadReplies + 5
+	createdAt := time.Now().Add(-time.Hour)
+	root := models.Comment{CreatedAt: createdAt, ID: primitive.NewObjectID().Hex(), PostId: postId, UserId: userId, Content: "root"}
+	root.RootId = root.ID
+	documents := []interface{}{root}
+	for i := 0; i < replies; i++ {
+		documents = append(documents, models.Comment{
+			CreatedAt: createdAt.Add(time.Duration(i+1) * time.Second),
+			ID:        primitive.NewObjectID().Hex(),
+			PostId:    postId,
+			ParentId:  root.ID,
+			RootId:    root.ID,
+			UserId:    userId,
+			Content:   "reply",
+		})
+	}
+	if _, err := db.Collection("Comment").InsertMany(context.Background(), documents); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	CommentRoutes(app, db)
+	get := func(path string, response interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", token)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+		if err = json.NewDecoder(resp.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var threads struct {
+		Data []models.CommentNode `json:"data"`
+	}
+	get("/comment/"+postId, &threads)
+	if len(threads.Data) != 1 {
+		t.Fatalf("got %d threads, want 1", len(threads.Data))
+	}
+	thread := threads.Data[0]
+	if len(thread.Replies) != maxThreadReplies || !thread.HasMoreReplies || thread.RepliesCursor == "" {
+		t.Fatalf("got %d replies, hasMoreReplies %v, want %d and more", len(thread.Replies), thread.HasMoreReplies, maxThreadReplies)
+	}
+
+	// the rest of the thread follows the cursor
+	var page struct {
+		Data       []models.Comment `json:"data"`
+		NextCursor string           `json:"nextCursor"`
+	}
+	get("/comment/"+postId+"/"+root.ID+"/replies?cursor="+url.QueryEscape(thread.RepliesCursor), &page)
+	if len(page.Data) != replies-maxThreadReplies || page.NextCursor != "" {
+		t.Fatalf("got %d replies and cursor %q, want %d and none", len(page.Data), page.NextCursor, replies-maxThreadReplies)
+	}
+	if page.Data[0].CreatedAt.Before(thread.Replies[len(thread.Replies)-1].CreatedAt) {
+		t.Errorf("the next replies start before the last one sent")
+	}
+}
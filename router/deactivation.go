@@ -0,0 +1,160 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultDeactivationGraceDays = 30
+
+// deactivationGrace is how long a deactivated account can come back before it
+// is deleted, from the env DEACTIVATION_GRACE_DAYS
+func deactivationGrace() time.Duration {
+	days := defaultDeactivationGraceDays
+	if value, err := strconv.Atoi(os.Getenv("DEACTIVATION_GRACE_DAYS")); err == nil && value >= 0 {
+		days = value
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// hide or show again all the posts of a user, the feeds leave out the posts
+// with authorHidden set so they do not have to look up the deactivated users
+func setAuthorHidden(ctx context.Context, db *mongo.Database, userId string, hidden bool) error {
+	filter := bson.M{"userId": userId, "authorHidden": true}
+	update := bson.M{"$unset": bson.M{"authorHidden": ""}}
+	if hidden {
+		filter = bson.M{"userId": userId, "authorHidden": nil}
+		update = bson.M{"$set": bson.M{"authorHidden": true}}
+	}
+	_, err := db.Collection("Post").UpdateMany(ctx, filter, update)
+	return err
+}
+
+// fix the posts of the users whose deactivation or reactivation failed before
+// their posts were updated
+func syncHiddenAuthors(ctx context.Context, db *mongo.Database) error {
+	userCollection := db.Collection("User")
+	cursor, err := userCollection.Find(ctx,
+		bson.M{"deactivatedAt": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err = setAuthorHidden(ctx, db, user.ID.Hex(), true); err != nil {
+			return err
+		}
+	}
+
+	userIds, err := db.Collection("Post").Distinct(ctx, "userId", bson.M{"authorHidden": true})
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		id, _ := userId.(string)
+		objId, _ := primitive.ObjectIDFromHex(id)
+		count, err := userCollection.CountDocuments(ctx, bson.M{"_id": objId, "deactivatedAt": bson.M{"$exists": true}})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if err = setAuthorHidden(ctx, db, id, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bring back a deactivated account on login, it fails once the grace period
+// is over or the deletion has started
+func reactivateUser(db *mongo.Database, userId primitive.ObjectID) (bool, error) {
+	res, err := db.Collection("User").UpdateOne(context.Background(),
+		bson.M{"_id": userId, "deletion": nil, "deactivatedAt": bson.M{"$gte": time.Now().Add(-deactivationGrace())}},
+		bson.M{"$unset": bson.M{"deactivatedAt": ""}})
+	if err != nil || res.MatchedCount == 0 {
+		return false, err
+	}
+	return true, setAuthorHidden(context.Background(), db, userId.Hex(), false)
+}
+
+// start the deletion of the accounts deactivated longer than the grace period ago
+func purgeDeactivatedUsers(ctx context.Context, db *mongo.Database) error {
+	expired := bson.M{"$lt": time.Now().Add(-deactivationGrace())}
+	cursor, err := db.Collection("User").Find(ctx, bson.M{"deactivatedAt": expired, "deletion": nil})
+	if err != nil {
+		return err
+	}
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return err
+	}
+	for _, user := range users {
+		// the user may have logged back in since it was read
+		_, err = startAccountDeletion(db, user.ID, bson.M{"deactivatedAt": expired})
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeactivateUser(db *mongo.Database, user fiber.Router) {
+	user.Post("/deactivate", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		// the tokens of the account are refused from here, logging in brings it back
+		objId, _ := primitive.ObjectIDFromHex(userID)
+		deactivatedAt := time.Now()
+		res, err := db.Collection("User").UpdateOne(context.Background(),
+			bson.M{"_id": objId, "deletion": nil, "deactivatedAt": nil},
+			bson.M{"$set": bson.M{"deactivatedAt": deactivatedAt}})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		if res.MatchedCount == 0 {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Not Found",
+			})
+		}
+		if err = setAuthorHidden(context.Background(), db, userID, true); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"deactivatedAt": deactivatedAt,
+				"purgeAt":       deactivatedAt.Add(deactivationGrace()),
+			},
+		})
+	})
+}
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"time"
+)
+
+var postStatuses = map[string]bool{
+	models.PostStatusDraft:     true,
+	models.PostStatusScheduled: true,
+	models.PostStatusPublished: true,
+}
+
+// check the status asked for a post, a scheduled post needs a date in the future
+func checkPostStatus(status string, publishAt *time.Time) error {
+	if !postStatuses[status] {
+		return errors.New("Invalid status")
+	}
+	if status == models.PostStatusScheduled && (publishAt == nil || !publishAt.After(time.Now())) {
+		return errors.New("Invalid publishAt")
+	}
+	return nil
+}
+
+// a post that is not published is only shown to its author
+func canSeePost(post models.Post, userId string) bool {
+	return post.Status == models.PostStatusPublished || post.UserId == userId
+}
+
+func GetDrafts(db *mongo.Database, post fiber.Router) {
+	post.Get("/drafts", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get a page of the drafts and scheduled posts of the user, newest first
+		postCollection := db.Collection("Post")
+		filter := bson.M{
+			"userId":    userID,
+			"status":    bson.M{"$in": bson.A{models.PostStatusDraft, models.PostStatusScheduled}},
+			"deletedAt": nil,
+		}
+		posts, nextCursor, err := findPostPage(postCollection, filter, SortNew, pageLimit(c), c.Query("cursor"))
+		if err == errInvalidCursor {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid cursor",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// change nil array to empty array
+		for i, post := range posts {
+			if post.UpVotes == nil {
+				posts[i].UpVotes = []string{}
+			}
+			if post.DownVotes == nil {
+				posts[i].DownVotes = []string{}
+			}
+			if post.Tags == nil {
+				posts[i].Tags = []string{}
+			}
+			if post.Attachments == nil {
+				posts[i].Attachments = []models.Attachment{}
+			}
+			posts[i].AvatarUrl = models.AvatarUrl(post.UserId)
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":         true,
+			"data":       posts,
+			"nextCursor": nextCursor,
+		})
+	})
+}
@@ -0,0 +1,176 @@
+package router
+
+import (
+	"containerized-go-app/export"
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"containerized-go-app/storage"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// only the latest notifications are listed
+const maxNotifications = 50
+
+// find an export of the user, the exports of other users are not found
+func findExport(db *mongo.Database, userId string, id string) (*models.Export, error) {
+	objId, _ := primitive.ObjectIDFromHex(id)
+	if objId.IsZero() {
+		return nil, mongo.ErrNoDocuments
+	}
+	export := models.Export{}
+	err := db.Collection("Export").FindOne(context.Background(), bson.M{"_id": objId, "userId": userId}).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func ExportUser(db *mongo.Database, exporter *export.Exporter, user fiber.Router) {
+	user.Post("/me/export", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		// asking again while an export is being built gives the same one
+		exportCollection := db.Collection("Export")
+		filter := bson.M{"userId": userID, "status": models.ExportStatusPending}
+		pending := models.Export{UserId: userID, Status: models.ExportStatusPending, CreatedAt: time.Now()}
+		err = exportCollection.FindOneAndUpdate(context.Background(),
+			filter,
+			bson.M{"$setOnInsert": pending},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&pending)
+		if mongo.IsDuplicateKeyError(err) {
+			// another request inserted the pending export at the same time
+			err = exportCollection.FindOne(context.Background(), filter).Decode(&pending)
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		exporter.Wake()
+
+		return c.Status(http.StatusAccepted).JSON(fiber.Map{
+			"ok":   true,
+			"data": pending,
+		})
+	})
+}
+
+func GetExport(db *mongo.Database, user fiber.Router) {
+	user.Get("/me/export/:id", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		export, err := findExport(db, userID, c.Params("id"))
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Export not found",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": export,
+		})
+	})
+}
+
+func DownloadExport(db *mongo.Database, store storage.Storage, user fiber.Router) {
+	user.Get("/me/export/:id/download", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		export, err := findExport(db, userID, c.Params("id"))
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Export not found",
+			})
+		}
+		if export.Status != models.ExportStatusReady {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Export not ready",
+			})
+		}
+
+		file, err := store.Open(export.Key())
+		if err == storage.ErrNotFound {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Export not found",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// the archive holds personal data, it must not be kept by caches
+		c.Set("Cache-Control", "private, no-store")
+		c.Set("Content-Type", "application/zip")
+		c.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "export-" + export.ID.Hex() + ".zip"}))
+		return c.SendStream(file, int(file.Size()))
+	})
+}
+
+func GetMyNotifications(db *mongo.Database, user fiber.Router) {
+	user.Get("/me/notifications", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		cursor, err := db.Collection("Notification").Find(context.Background(),
+			bson.M{"userId": userID},
+			options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(maxNotifications))
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		notifications := []models.Notification{}
+		if err = cursor.All(context.Background(), &notifications); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": notifications,
+		})
+	})
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"containerized-go-app/models"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// orders of a feed, each one is followed by _id to break ties
+const (
+	SortNew = "new"
+	SortHot = "hot"
+	SortTop = "top"
+)
+
+// field of the post each order sorts on, descending
+var sortKeys = map[string]string{
+	SortNew: "createdAt",
+	SortHot: "hotScore",
+	SortTop: "score",
+}
+
+// how far back the hot and top feeds look
+var feedWindows = map[string]time.Duration{
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+	"all":  0,
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// find a page of posts in the given order, the next page starts after the returned cursor
+func findPostPage(postCollection *mongo.Collection, filter bson.M, sort string, limit int, cursor string) ([]models.Post, string, error) {
+	sortKey := sortKeys[sort]
+	if cursor != "" {
+		value, id, err := decodeFeedCursor(sort, cursor)
+		if err != nil {
+			return nil, "", errInvalidCursor
+		}
+		objId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, "", errInvalidCursor
+		}
+		// posts with the same sort value are ordered by id
+		filter["$or"] = bson.A{
+			bson.M{sortKey: bson.M{"$lt": value}},
+			bson.M{sortKey: value, "_id": bson.M{"$lt": objId}},
+		}
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: sortKey, Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+	res, err := postCollection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, "", err
+	}
+	posts := []models.Post{}
+	if err = res.All(context.Background(), &posts); err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(posts) > limit {
+		posts = posts[:limit]
+		nextCursor = encodeFeedCursor(sort, posts[len(posts)-1])
+	}
+	return posts, nextCursor, nil
+}
+
+func encodeFeedCursor(sort string, post models.Post) string {
+	switch sort {
+	case SortHot:
+		return encodeScoreCursor(post.HotScore, post.ID.Hex())
+	case SortTop:
+		return encodeScoreCursor(float64(post.Score), post.ID.Hex())
+	default:
+		return encodeCursor(post.CreatedAt, post.ID.Hex())
+	}
+}
+
+func decodeFeedCursor(sort string, cursor string) (interface{}, string, error) {
+	switch sort {
+	case SortHot:
+		return decodeScoreCursor(cursor)
+	case SortTop:
+		score, id, err := decodeScoreCursor(cursor)
+		return int(score), id, err
+	default:
+		return decodeCursor(cursor)
+	}
+}
@@ -0,0 +1,130 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+)
+
+// the pinned posts are all shown on the first page, a post cannot be pinned
+// once there are this many
+const maxPinnedPosts = 10
+
+// find the pinned posts among the ones of a feed, newest first. They are not
+// cut off, the pages of the feed leave them out.
+func findPinnedPosts(postCollection *mongo.Collection, filter bson.M) ([]models.Post, error) {
+	filter["pinned"] = true
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := postCollection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	posts := []models.Post{}
+	if err = cursor.All(context.Background(), &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func isModerator(db *mongo.Database, userId string) bool {
+	objId, _ := primitive.ObjectIDFromHex(userId)
+	user := models.User{}
+	err := db.Collection("User").FindOne(context.Background(), bson.M{"_id": objId}).Decode(&user)
+	return err == nil && user.Role == models.RoleModerator
+}
+
+func PinPostById(db *mongo.Database, post fiber.Router) {
+	post.Post("/:id/pin", moderatePost(db, "pinned", true))
+	post.Delete("/:id/pin", moderatePost(db, "pinned", false))
+}
+
+func LockPostById(db *mongo.Database, post fiber.Router) {
+	post.Post("/:id/lock", moderatePost(db, "locked", true))
+	post.Delete("/:id/lock", moderatePost(db, "locked", false))
+}
+
+// set or clear a moderation flag of a published post
+func moderatePost(db *mongo.Database, field string, value bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// only moderators can pin and lock posts
+		if !isModerator(db, UserId) {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Forbidden",
+			})
+		}
+
+		// check if id is valid
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// the first page holds every pinned post, their number is bounded
+		postCollection := db.Collection("Post")
+		if field == "pinned" && value {
+			count, err := postCollection.CountDocuments(context.Background(),
+				bson.M{"pinned": true, "status": models.PostStatusPublished, "deletedAt": nil, "_id": bson.M{"$ne": objId}})
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+			if count >= maxPinnedPosts {
+				return c.Status(http.StatusConflict).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Too many pinned posts",
+				})
+			}
+		}
+
+		// the flag is removed rather than set to false, like on the posts that never had it
+		update := bson.M{"$unset": bson.M{field: ""}}
+		if value {
+			update = bson.M{"$set": bson.M{field: true}}
+		}
+		res, err := postCollection.UpdateOne(context.Background(),
+			bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil},
+			update)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		if res.MatchedCount == 0 {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok": true,
+			"data": fiber.Map{
+				"id":  objId.Hex(),
+				field: value,
+			},
+		})
+	}
+}
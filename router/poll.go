@@ -0,0 +1,210 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	minPollOptions    = 2
+	maxPollOptions    = 10
+	maxPollOptionText = 100
+)
+
+// pollRequest is the poll sent with a new post
+type pollRequest struct {
+	Options  []string   `json:"options"`
+	Multiple bool       `json:"multiple"`
+	ClosesAt *time.Time `json:"closesAt"`
+}
+
+// check a poll request and build the poll to store in the post
+func newPoll(request pollRequest) (*models.Poll, error) {
+	if len(request.Options) < minPollOptions || len(request.Options) > maxPollOptions {
+		return nil, errors.New("Invalid poll")
+	}
+	if request.ClosesAt != nil && !request.ClosesAt.After(time.Now()) {
+		return nil, errors.New("Invalid poll")
+	}
+
+	poll := &models.Poll{
+		Options:  []models.PollOption{},
+		Multiple: request.Multiple,
+		ClosesAt: request.ClosesAt,
+		Ballots:  []models.PollBallot{},
+	}
+	seen := map[string]bool{}
+	for i, text := range request.Options {
+		text = strings.TrimSpace(text)
+		if text == "" || len(text) > maxPollOptionText || seen[strings.ToLower(text)] {
+			return nil, errors.New("Invalid poll")
+		}
+		seen[strings.ToLower(text)] = true
+		poll.Options = append(poll.Options, models.PollOption{ID: i, Text: text})
+	}
+	return poll, nil
+}
+
+func pollClosed(poll *models.Poll) bool {
+	return poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now())
+}
+
+// show a poll to a user, the tallies are hidden until the user votes or the poll closes
+func pollView(poll *models.Poll, userId string) fiber.Map {
+	if poll == nil {
+		return nil
+	}
+
+	var choices []int
+	for _, ballot := range poll.Ballots {
+		if ballot.UserId == userId {
+			choices = ballot.Choices
+			break
+		}
+	}
+	closed := pollClosed(poll)
+	showResults := closed || choices != nil
+
+	options := []fiber.Map{}
+	for _, option := range poll.Options {
+		view := fiber.Map{"id": option.ID, "text": option.Text}
+		if showResults {
+			view["votes"] = option.Votes
+		}
+		options = append(options, view)
+	}
+	view := fiber.Map{
+		"options":  options,
+		"multiple": poll.Multiple,
+		"closesAt": poll.ClosesAt,
+		"closed":   closed,
+		"voted":    choices != nil,
+		"choices":  choices,
+	}
+	if showResults {
+		view["voters"] = len(poll.Ballots)
+	}
+	return view
+}
+
+func VotePollById(db *mongo.Database, post fiber.Router) {
+	post.Post("/:id/poll", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get ballot request
+		var ballotRequest struct {
+			Choices []int `json:"choices"`
+		}
+		if err := c.BodyParser(&ballotRequest); err != nil || len(ballotRequest.Choices) == 0 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
+		// check if id is valid
+		postCollection := db.Collection("Post")
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// get post from db
+		post := models.Post{}
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
+		if err != nil || post.Poll == nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Poll not found",
+			})
+		}
+		if post.Locked {
+			return c.Status(http.StatusLocked).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post is locked",
+			})
+		}
+		if pollClosed(post.Poll) {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Poll is closed",
+			})
+		}
+
+		// a single choice poll takes one choice, each choice is counted once
+		if !post.Poll.Multiple && len(ballotRequest.Choices) > 1 {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid choices",
+			})
+		}
+		votes := bson.M{}
+		for _, choice := range ballotRequest.Choices {
+			key := fmt.Sprintf("poll.options.%d.votes", choice)
+			if choice < 0 || choice >= len(post.Poll.Options) || votes[key] != nil {
+				return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Invalid choices",
+				})
+			}
+			votes[key] = 1
+		}
+
+		// the ballot and the tallies are written together, only if the user has not
+		// voted yet and the poll is still open
+		now := time.Now()
+		updatedPost := models.Post{}
+		err = postCollection.FindOneAndUpdate(context.Background(),
+			bson.M{
+				"_id":                 objId,
+				"poll.ballots.userId": bson.M{"$ne": UserId},
+				"$or": bson.A{
+					bson.M{"poll.closesAt": nil},
+					bson.M{"poll.closesAt": bson.M{"$gt": now}},
+				},
+			},
+			bson.M{
+				"$push": bson.M{"poll.ballots": models.PollBallot{UserId: UserId, Choices: ballotRequest.Choices}},
+				"$inc":  votes,
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updatedPost)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Already voted",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": pollView(updatedPost.Poll, UserId),
+		})
+	})
+}
@@ -1,30 +1,49 @@
 package router
 
 import (
+	"containerized-go-app/diff"
 	"containerized-go-app/jwt"
+	"containerized-go-app/markdown"
 	"containerized-go-app/models"
+	"containerized-go-app/rank"
+	"containerized-go-app/scheduler"
+	"containerized-go-app/storage"
 	"context"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func PostRoutes(app *fiber.App, db *mongo.Database) {
+func PostRoutes(app *fiber.App, db *mongo.Database, store storage.Storage, publisher *scheduler.Scheduler) {
 	post := app.Group("/post", func(c *fiber.Ctx) error {
 		return c.Next()
 	})
 	GetPosts(db, post)
 	GetMyPosts(db, post)
+	SearchPosts(db, post)
+	GetDrafts(db, post)
+	GetTrash(db, post)
 	GetPostById(db, post)
-	CreatePost(db, post)
+	GetPostRevisions(db, post)
+	CreatePost(db, publisher, post)
+	EditPostById(db, publisher, post)
 	DeletePostById(db, post)
+	RestorePostById(db, publisher, post)
+	PinPostById(db, post)
+	LockPostById(db, post)
+	VotePollById(db, post)
+	BookmarkPostById(db, post)
 	VotePostById(db, post)
+	UploadAttachment(db, store, post)
 }
 
-func CreatePost(db *mongo.Database, post fiber.Router) {
+func CreatePost(db *mongo.Database, publisher *scheduler.Scheduler, post fiber.Router) {
 	post.Post("/", func(c *fiber.Ctx) error {
 		// get user id and authorization from token
 		userId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
@@ -44,6 +63,47 @@ func CreatePost(db *mongo.Database, post fiber.Router) {
 			})
 		}
 
+		tags, err := normalizeTags(postRequest.Tags)
+		if err != nil {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid tags",
+			})
+		}
+
+		// posts are published right away unless they are saved as draft or scheduled
+		status := postRequest.Status
+		if status == "" {
+			status = models.PostStatusPublished
+		}
+		if err := checkPostStatus(status, postRequest.PublishAt); err != nil {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": err.Error(),
+			})
+		}
+
+		// a poll can only be added when the post is created
+		var withPoll struct {
+			Poll *pollRequest `json:"poll"`
+		}
+		if err := c.BodyParser(&withPoll); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+		var poll *models.Poll
+		if withPoll.Poll != nil {
+			poll, err = newPoll(*withPoll.Poll)
+			if err != nil {
+				return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+					"ok":    false,
+					"error": err.Error(),
+				})
+			}
+		}
+
 		// get user from db
 		userCollection := db.Collection("User")
 		objId, _ := primitive.ObjectIDFromHex(userId)
@@ -53,14 +113,23 @@ func CreatePost(db *mongo.Database, post fiber.Router) {
 		postCollection := db.Collection("Post")
 
 		// create new post
+		createdAt := time.Now()
 		newPost := models.Post{
-			CreatedAt: time.Now(),
-			UserId:    userId,
-			FirstName: user.FirstName,
-			Title:     postRequest.Title,
-			Content:   postRequest.Content,
-			Comments:  []models.Comment{},
-			UpVotes:   []string{},
+			CreatedAt:   createdAt,
+			UserId:      userId,
+			FirstName:   user.FirstName,
+			Title:       postRequest.Title,
+			Content:     postRequest.Content,
+			ContentHtml: markdown.Render(postRequest.Content),
+			Tags:        tags,
+			UpVotes:     []string{},
+			DownVotes:   []string{},
+			HotScore:    rank.Hot(0, createdAt),
+			Status:      status,
+			Poll:        poll,
+		}
+		if status == models.PostStatusScheduled {
+			newPost.PublishAt = postRequest.PublishAt
 		}
 
 		// insert post to db
@@ -71,13 +140,8 @@ func CreatePost(db *mongo.Database, post fiber.Router) {
 				"error": "Internal Server Error",
 			})
 		}
-
-		_, err = userCollection.UpdateOne(context.Background(), bson.M{"_id": objId}, bson.M{"$set": bson.M{"comments": []models.Comment{}}})
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"ok":    false,
-				"error": "Internal Server Error",
-			})
+		if status == models.PostStatusScheduled {
+			publisher.Wake()
 		}
 
 		_, err = userCollection.UpdateOne(context.Background(), bson.M{"_id": objId}, bson.M{"$set": bson.M{"upVotes": []string{}}})
@@ -91,13 +155,21 @@ func CreatePost(db *mongo.Database, post fiber.Router) {
 		return c.Status(http.StatusCreated).JSON(fiber.Map{
 			"ok": true,
 			"data": fiber.Map{
-				"createdAt": newPost.CreatedAt,
-				"userId":    newPost.UserId,
-				"firstName": newPost.FirstName,
-				"title":     newPost.Title,
-				"content":   newPost.Content,
-				"comments":  newPost.Comments,
-				"upVotes":   newPost.UpVotes,
+				"createdAt":    newPost.CreatedAt,
+				"userId":       newPost.UserId,
+				"firstName":    newPost.FirstName,
+				"avatarUrl":    models.AvatarUrl(newPost.UserId),
+				"title":        newPost.Title,
+				"content":      newPost.Content,
+				"contentHtml":  newPost.ContentHtml,
+				"tags":         newPost.Tags,
+				"commentCount": newPost.CommentCount,
+				"upVotes":      newPost.UpVotes,
+				"downVotes":    newPost.DownVotes,
+				"score":        newPost.Score,
+				"status":       newPost.Status,
+				"publishAt":    newPost.PublishAt,
+				"poll":         pollView(newPost.Poll, userId),
 			},
 		})
 	})
@@ -105,7 +177,7 @@ func CreatePost(db *mongo.Database, post fiber.Router) {
 
 func GetPosts(db *mongo.Database, post fiber.Router) {
 	post.Get("/", func(c *fiber.Ctx) error {
-		_, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
 		if err != nil {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
 				"ok":    false,
@@ -113,18 +185,59 @@ func GetPosts(db *mongo.Database, post fiber.Router) {
 			})
 		}
 
-		// get all posts
+		// get the order of the feed, newest first by default
+		sort := c.Query("sort", SortNew)
+		window, ok := feedWindows[c.Query("window", "all")]
+		if _, valid := sortKeys[sort]; !valid || !ok {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
+		// get a page of posts
 		postCollection := db.Collection("Post")
-		cursor, err := postCollection.Find(context.Background(), bson.M{})
+		filter := bson.M{"status": models.PostStatusPublished, "authorHidden": nil, "deletedAt": nil}
+		if tag := c.Query("tag"); tag != "" {
+			filter["tags"] = normalizeTag(tag)
+		}
+
+		// pinned posts come before the first page whatever the order and the window,
+		// the pages only hold the other posts so the cursor is not affected
+		pinned := []models.Post{}
+		if c.Query("cursor") == "" {
+			pinnedFilter := bson.M{}
+			for key, value := range filter {
+				pinnedFilter[key] = value
+			}
+			pinned, err = findPinnedPosts(postCollection, pinnedFilter)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+		}
+		filter["pinned"] = bson.M{"$ne": true}
+
+		if window > 0 {
+			filter["createdAt"] = bson.M{"$gte": time.Now().Add(-window)}
+		}
+		posts, nextCursor, err := findPostPage(postCollection, filter, sort, pageLimit(c), c.Query("cursor"))
+		if err == errInvalidCursor {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid cursor",
+			})
+		}
 		if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Internal Server Error",
 			})
 		}
-
-		posts := []models.Post{}
-		if err = cursor.All(context.Background(), &posts); err != nil {
+		posts = append(pinned, posts...)
+		if err = markBookmarked(db, userID, posts); err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Internal Server Error",
@@ -136,13 +249,21 @@ func GetPosts(db *mongo.Database, post fiber.Router) {
 			if post.UpVotes == nil {
 				posts[i].UpVotes = []string{}
 			}
-			if post.Comments == nil {
-				posts[i].Comments = []models.Comment{}
+			if post.DownVotes == nil {
+				posts[i].DownVotes = []string{}
+			}
+			if post.Tags == nil {
+				posts[i].Tags = []string{}
 			}
+			if post.Attachments == nil {
+				posts[i].Attachments = []models.Attachment{}
+			}
+			posts[i].AvatarUrl = models.AvatarUrl(post.UserId)
 		}
 		return c.Status(http.StatusOK).JSON(fiber.Map{
-			"ok":   true,
-			"data": posts,
+			"ok":         true,
+			"data":       posts,
+			"nextCursor": nextCursor,
 		})
 	})
 }
@@ -158,16 +279,22 @@ func GetMyPosts(db *mongo.Database, post fiber.Router) {
 		}
 
 		postCollection := db.Collection("Post")
-		//get all posts of the user
-		cursor, err := postCollection.Find(context.Background(), bson.M{"userId": userID})
+		//get a page of published posts of the user, newest first
+		posts, nextCursor, err := findPostPage(postCollection, bson.M{"userId": userID, "status": models.PostStatusPublished, "deletedAt": nil}, SortNew, pageLimit(c), c.Query("cursor"))
+		if err == errInvalidCursor {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid cursor",
+			})
+		}
 		if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Internal Server Error",
 			})
 		}
-		posts := []models.Post{}
-		if err = cursor.All(context.Background(), &posts); err != nil {
+
+		if err = markBookmarked(db, userID, posts); err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Internal Server Error",
@@ -179,14 +306,151 @@ func GetMyPosts(db *mongo.Database, post fiber.Router) {
 			if post.UpVotes == nil {
 				posts[i].UpVotes = []string{}
 			}
-			if post.Comments == nil {
-				posts[i].Comments = []models.Comment{}
+			if post.DownVotes == nil {
+				posts[i].DownVotes = []string{}
+			}
+			if post.Tags == nil {
+				posts[i].Tags = []string{}
 			}
+			if post.Attachments == nil {
+				posts[i].Attachments = []models.Attachment{}
+			}
+			posts[i].AvatarUrl = models.AvatarUrl(post.UserId)
 		}
 
 		return c.Status(http.StatusOK).JSON(fiber.Map{
-			"ok":   true,
-			"data": posts,
+			"ok":         true,
+			"data":       posts,
+			"nextCursor": nextCursor,
+		})
+	})
+}
+
+func SearchPosts(db *mongo.Database, post fiber.Router) {
+	post.Get("/search", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
+		// combine the text search with the author and date filters
+		filter := bson.M{"$text": bson.M{"$search": query}, "status": models.PostStatusPublished, "authorHidden": nil, "deletedAt": nil}
+		if author := c.Query("author"); author != "" {
+			filter["userId"] = author
+		}
+		createdAt := bson.M{}
+		if from := c.Query("from"); from != "" {
+			t, err := parseSearchDate(from, false)
+			if err != nil {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Invalid date",
+				})
+			}
+			createdAt["$gte"] = t
+		}
+		if to := c.Query("to"); to != "" {
+			t, err := parseSearchDate(to, true)
+			if err != nil {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Invalid date",
+				})
+			}
+			createdAt["$lte"] = t
+		}
+		if len(createdAt) > 0 {
+			filter["createdAt"] = createdAt
+		}
+
+		// results are ranked by relevance, so the cursor is the offset of the next page
+		offset := 0
+		if cursor := c.Query("cursor"); cursor != "" {
+			offset, err = strconv.Atoi(cursor)
+			if err != nil || offset < 0 {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Invalid cursor",
+				})
+			}
+		}
+		limit := pageLimit(c)
+
+		postCollection := db.Collection("Post")
+		findOptions := options.Find().
+			SetProjection(bson.M{"relevance": bson.M{"$meta": "textScore"}}).
+			SetSort(bson.D{{Key: "relevance", Value: bson.M{"$meta": "textScore"}}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64(offset)).
+			SetLimit(int64(limit + 1))
+		cursor, err := postCollection.Find(context.Background(), filter, findOptions)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		results := []searchResult{}
+		if err = cursor.All(context.Background(), &results); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		nextCursor := ""
+		if len(results) > limit {
+			results = results[:limit]
+			nextCursor = strconv.Itoa(offset + limit)
+		}
+
+		postIds := []string{}
+		for _, result := range results {
+			postIds = append(postIds, result.ID.Hex())
+		}
+		bookmarked, err := bookmarkedPosts(db, userID, postIds)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// highlight the searched words
+		terms := searchTerms(query)
+		for i, result := range results {
+			if result.UpVotes == nil {
+				results[i].UpVotes = []string{}
+			}
+			if result.DownVotes == nil {
+				results[i].DownVotes = []string{}
+			}
+			if result.Tags == nil {
+				results[i].Tags = []string{}
+			}
+			if result.Attachments == nil {
+				results[i].Attachments = []models.Attachment{}
+			}
+			results[i].AvatarUrl = models.AvatarUrl(result.UserId)
+			results[i].Bookmarked = bookmarked[result.ID.Hex()]
+			results[i].TitleHighlight = highlight(result.Title, terms)
+			results[i].Snippet = snippet(result.Content, terms)
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":         true,
+			"data":       results,
+			"nextCursor": nextCursor,
 		})
 	})
 }
@@ -194,7 +458,7 @@ func GetMyPosts(db *mongo.Database, post fiber.Router) {
 func GetPostById(db *mongo.Database, post fiber.Router) {
 	post.Get("/:id", func(c *fiber.Ctx) error {
 		// get user id and authorization from token
-		_, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
 		if err != nil {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
 				"ok":    false,
@@ -209,37 +473,357 @@ func GetPostById(db *mongo.Database, post fiber.Router) {
 		post := models.Post{}
 
 		// get post from db
-		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&post)
-		if err != nil {
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "deletedAt": nil}).Decode(&post)
+		if err != nil || !canSeePost(post, UserId) {
 			return c.Status(http.StatusNotFound).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Post not found",
 			})
 		}
 
+		bookmarked, err := bookmarkedPosts(db, UserId, []string{post.ID.Hex()})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
 		// change nil array to empty array
 		if post.UpVotes == nil {
 			post.UpVotes = []string{}
 		}
-		if post.Comments == nil {
-			post.Comments = []models.Comment{}
+		if post.DownVotes == nil {
+			post.DownVotes = []string{}
+		}
+		if post.Tags == nil {
+			post.Tags = []string{}
+		}
+		if post.Attachments == nil {
+			post.Attachments = []models.Attachment{}
 		}
 
 		return c.Status(http.StatusOK).JSON(fiber.Map{
 			"ok": true,
 			"data": fiber.Map{
-				"createdAt": post.CreatedAt,
-				"userId":    post.UserId,
-				"firstName": post.FirstName,
-				"title":     post.Title,
-				"content":   post.Content,
-				"comments":  post.Comments,
-				"upVotes":   post.UpVotes,
+				"createdAt":    post.CreatedAt,
+				"userId":       post.UserId,
+				"firstName":    post.FirstName,
+				"avatarUrl":    models.AvatarUrl(post.UserId),
+				"title":        post.Title,
+				"content":      post.Content,
+				"contentHtml":  post.ContentHtml,
+				"tags":         post.Tags,
+				"commentCount": post.CommentCount,
+				"upVotes":      post.UpVotes,
+				"downVotes":    post.DownVotes,
+				"score":        post.Score,
+				"editedAt":     post.EditedAt,
+				"attachments":  post.Attachments,
+				"status":       post.Status,
+				"publishAt":    post.PublishAt,
+				"pinned":       post.Pinned,
+				"locked":       post.Locked,
+				"poll":         pollView(post.Poll, UserId),
+				"bookmarked":   bookmarked[post.ID.Hex()],
 			},
 		})
 	})
 }
 
+func EditPostById(db *mongo.Database, publisher *scheduler.Scheduler, post fiber.Router) {
+	post.Put("/:id", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get post request, at least one field must change
+		var postRequest models.Post
+		if err := c.BodyParser(&postRequest); err != nil ||
+			(postRequest.Title == "" && postRequest.Content == "" && postRequest.Tags == nil &&
+				postRequest.Status == "" && postRequest.PublishAt == nil) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
+		tags, err := normalizeTags(postRequest.Tags)
+		if err != nil {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid tags",
+			})
+		}
+
+		// get post by id
+		postCollection := db.Collection("Post")
+		postId := c.Params("id")
+		objId, _ := primitive.ObjectIDFromHex(postId)
+		post := models.Post{}
+
+		// get post from db
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "deletedAt": nil}).Decode(&post)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// check if the post belongs to the user
+		if post.UserId != UserId {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Forbidden",
+			})
+		}
+
+		// only a post that is not published yet can change its status
+		status := post.Status
+		if postRequest.Status != "" || postRequest.PublishAt != nil {
+			if post.Status == models.PostStatusPublished {
+				return c.Status(http.StatusConflict).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Post already published",
+				})
+			}
+			if postRequest.Status != "" {
+				status = postRequest.Status
+			}
+			if err := checkPostStatus(status, postRequest.PublishAt); err != nil {
+				return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+					"ok":    false,
+					"error": err.Error(),
+				})
+			}
+		}
+
+		update := bson.M{}
+		unset := bson.M{}
+		if postRequest.Title != "" {
+			update["title"] = postRequest.Title
+		}
+		if postRequest.Content != "" {
+			update["content"] = postRequest.Content
+			update["contentHtml"] = markdown.Render(postRequest.Content)
+		}
+		if postRequest.Tags != nil {
+			update["tags"] = tags
+		}
+
+		// a published draft gets the date of its publication so it shows up at the top of the feeds
+		now := time.Now()
+		switch {
+		case status == models.PostStatusPublished && post.Status != models.PostStatusPublished:
+			update["status"] = status
+			update["createdAt"] = now
+			update["hotScore"] = rank.Hot(post.Score, now)
+			unset["publishAt"] = ""
+			post.CreatedAt = now
+			post.PublishAt = nil
+		case status == models.PostStatusScheduled:
+			// a scheduled post keeps its date unless a new one is given
+			update["status"] = status
+			if postRequest.PublishAt != nil {
+				update["publishAt"] = postRequest.PublishAt
+				post.PublishAt = postRequest.PublishAt
+			}
+		case status == models.PostStatusDraft:
+			update["status"] = status
+			unset["publishAt"] = ""
+			post.PublishAt = nil
+		}
+
+		// only the edits of a published post are kept in its history
+		editedAt := post.EditedAt
+		if post.Status == models.PostStatusPublished {
+			editedAt = &now
+			update["editedAt"] = now
+		}
+		changes := bson.M{"$set": update}
+		if len(unset) > 0 {
+			changes["$unset"] = unset
+		}
+
+		// update the post and get the version it replaces, the status must not have
+		// been changed by the scheduler in the meantime
+		previous := models.Post{}
+		err = postCollection.FindOneAndUpdate(context.Background(),
+			bson.M{"_id": objId, "userId": UserId, "status": post.Status, "deletedAt": nil},
+			changes,
+			options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previous)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post already published",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		if status == models.PostStatusScheduled {
+			publisher.Wake()
+		}
+		post.Status = status
+
+		if previous.Status != models.PostStatusPublished {
+			return c.Status(http.StatusOK).JSON(editedPostResponse(post, postRequest, tags, editedAt))
+		}
+
+		// save the previous version in the history
+		revision := models.PostRevision{
+			PostId:    previous.ID.Hex(),
+			Title:     previous.Title,
+			Content:   previous.Content,
+			CreatedAt: previous.CreatedAt,
+			RevisedAt: now,
+		}
+		if previous.EditedAt != nil {
+			revision.CreatedAt = *previous.EditedAt
+		}
+		_, err = db.Collection("PostRevision").InsertOne(context.Background(), revision)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(editedPostResponse(post, postRequest, tags, editedAt))
+	})
+}
+
+// build the response of an edit from the post before it and the fields that changed
+func editedPostResponse(post models.Post, postRequest models.Post, tags []string, editedAt *time.Time) fiber.Map {
+	if postRequest.Title != "" {
+		post.Title = postRequest.Title
+	}
+	if postRequest.Content != "" {
+		post.Content = postRequest.Content
+		post.ContentHtml = markdown.Render(postRequest.Content)
+	}
+	if postRequest.Tags != nil {
+		post.Tags = tags
+	}
+	if post.UpVotes == nil {
+		post.UpVotes = []string{}
+	}
+	if post.DownVotes == nil {
+		post.DownVotes = []string{}
+	}
+	if post.Tags == nil {
+		post.Tags = []string{}
+	}
+	if post.Attachments == nil {
+		post.Attachments = []models.Attachment{}
+	}
+
+	return fiber.Map{
+		"ok": true,
+		"data": fiber.Map{
+			"createdAt":    post.CreatedAt,
+			"userId":       post.UserId,
+			"firstName":    post.FirstName,
+			"avatarUrl":    models.AvatarUrl(post.UserId),
+			"title":        post.Title,
+			"content":      post.Content,
+			"contentHtml":  post.ContentHtml,
+			"tags":         post.Tags,
+			"commentCount": post.CommentCount,
+			"upVotes":      post.UpVotes,
+			"downVotes":    post.DownVotes,
+			"score":        post.Score,
+			"editedAt":     editedAt,
+			"attachments":  post.Attachments,
+			"status":       post.Status,
+			"publishAt":    post.PublishAt,
+			"pinned":       post.Pinned,
+			"locked":       post.Locked,
+			"poll":         pollView(post.Poll, post.UserId),
+		},
+	}
+}
+
+func GetPostRevisions(db *mongo.Database, post fiber.Router) {
+	post.Get("/:id/revisions", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get post by id
+		postCollection := db.Collection("Post")
+		postId := c.Params("id")
+		objId, _ := primitive.ObjectIDFromHex(postId)
+		post := models.Post{}
+
+		// get post from db
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "deletedAt": nil}).Decode(&post)
+		if err != nil || !canSeePost(post, UserId) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
+
+		// get the previous versions, oldest first
+		cursor, err := db.Collection("PostRevision").Find(context.Background(),
+			bson.M{"postId": post.ID.Hex()},
+			options.Find().SetSort(bson.D{{Key: "revisedAt", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+		revisions := []models.PostRevision{}
+		if err = cursor.All(context.Background(), &revisions); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// each revision is compared to the version that replaced it
+		current := models.PostRevision{PostId: post.ID.Hex(), Title: post.Title, Content: post.Content, CreatedAt: post.CreatedAt}
+		if post.EditedAt != nil {
+			current.CreatedAt = *post.EditedAt
+		}
+		versions := append(revisions, current)
+		history := []fiber.Map{}
+		for i := len(revisions) - 1; i >= 0; i-- {
+			next := versions[i+1]
+			history = append(history, fiber.Map{
+				"version":     i + 1,
+				"title":       revisions[i].Title,
+				"content":     revisions[i].Content,
+				"createdAt":   revisions[i].CreatedAt,
+				"revisedAt":   revisions[i].RevisedAt,
+				"titleDiff":   diff.Lines(revisions[i].Title, next.Title),
+				"contentDiff": diff.Lines(revisions[i].Content, next.Content),
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": history,
+		})
+	})
+}
+
 func DeletePostById(db *mongo.Database, post fiber.Router) {
 	post.Delete("/:id", func(c *fiber.Ctx) error {
 		// get user id and authorization from token
@@ -258,7 +842,7 @@ func DeletePostById(db *mongo.Database, post fiber.Router) {
 		post := models.Post{}
 
 		// get post from db
-		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&post)
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "deletedAt": nil}).Decode(&post)
 		if err != nil {
 			return c.Status(http.StatusNotFound).JSON(fiber.Map{
 				"ok":    false,
@@ -274,26 +858,40 @@ func DeletePostById(db *mongo.Database, post fiber.Router) {
 			})
 		}
 
-		// delete post
-		_, err = postCollection.DeleteOne(context.Background(), bson.M{"_id": objId})
+		// move the post to the trash, it is purged for good once the retention is over
+		deletedAt := time.Now()
+		res, err := postCollection.UpdateOne(context.Background(),
+			bson.M{"_id": objId, "userId": UserId, "deletedAt": nil},
+			bson.M{"$set": bson.M{"deletedAt": deletedAt}})
 		if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Internal Server Error",
 			})
 		}
+		if res.MatchedCount == 0 {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found",
+			})
+		}
 
 		return c.Status(http.StatusOK).JSON(fiber.Map{
 			"ok": true,
 			"message": fiber.Map{
-				"createdAt": post.CreatedAt,
-				"userId":    post.UserId,
-				"firstName": post.FirstName,
-				"title":     post.Title,
-				"content":   post.Content,
-				"comments":  post.Comments,
-				"upVotes":   post.UpVotes,
-				"removed":   true,
+				"createdAt":    post.CreatedAt,
+				"userId":       post.UserId,
+				"firstName":    post.FirstName,
+				"avatarUrl":    models.AvatarUrl(post.UserId),
+				"title":        post.Title,
+				"content":      post.Content,
+				"contentHtml":  post.ContentHtml,
+				"commentCount": post.CommentCount,
+				"upVotes":      post.UpVotes,
+				"downVotes":    post.DownVotes,
+				"score":        post.Score,
+				"deletedAt":    deletedAt,
+				"removed":      true,
 			},
 		})
 	})
@@ -310,6 +908,28 @@ func VotePostById(db *mongo.Database, post fiber.Router) {
 			})
 		}
 
+		// get vote request, an empty body is an upvote
+		var voteRequest struct {
+			Vote string `json:"vote"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&voteRequest); err != nil {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Bad Request",
+				})
+			}
+		}
+		if voteRequest.Vote == "" {
+			voteRequest.Vote = VoteUp
+		}
+		if voteRequest.Vote != VoteUp && voteRequest.Vote != VoteDown && voteRequest.Vote != VoteClear {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Bad Request",
+			})
+		}
+
 		// get post by id
 		postCollection := db.Collection("Post")
 		postId := c.Params("id")
@@ -325,7 +945,7 @@ func VotePostById(db *mongo.Database, post fiber.Router) {
 		}
 
 		// get post from db
-		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&post)
+		err = postCollection.FindOne(context.Background(), bson.M{"_id": objId, "status": models.PostStatusPublished, "deletedAt": nil}).Decode(&post)
 		if err != nil {
 			return c.Status(http.StatusNotFound).JSON(fiber.Map{
 				"ok":    false,
@@ -333,47 +953,33 @@ func VotePostById(db *mongo.Database, post fiber.Router) {
 			})
 		}
 
-		//get user from db
-		userCollection := db.Collection("User")
-		objId, _ = primitive.ObjectIDFromHex(UserId)
-		user := models.User{}
-		err = userCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&user)
-		if err != nil {
-			return c.Status(http.StatusNotFound).JSON(fiber.Map{
-				"ok":    false,
-				"error": "User not found",
-			})
-		}
-
-		// check if the user has already voted
-		for _, upVote := range post.UpVotes {
-			if upVote == UserId {
-				return c.Status(http.StatusConflict).JSON(fiber.Map{
-					"ok":    false,
-					"error": "User has already voted",
-				})
-			}
-		}
-
-		//check if the user has voted in the last 1 minutes
-		if time.Now().Sub(user.LastUpVote) < time.Minute {
-			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+		// locked posts can still be read but not voted
+		if post.Locked {
+			return c.Status(http.StatusLocked).JSON(fiber.Map{
 				"ok":    false,
-				"error": "User has voted in the last 1 minute",
+				"error": "Post is locked",
 			})
 		}
 
-		// create upVotes field in post in db
-		if post.UpVotes == nil {
-			_, err = postCollection.UpdateOne(context.Background(), bson.M{"_id": objId}, bson.M{"$set": bson.M{"upVotes": []string{"id"}}})
-			if err != nil {
-				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		// claim the 1 minute cooldown of the user, only one request can win it
+		userCollection := db.Collection("User")
+		userObjId, _ := primitive.ObjectIDFromHex(UserId)
+		votedAt := time.Now()
+		user := models.User{}
+		if voteRequest.Vote != VoteClear {
+			err = userCollection.FindOneAndUpdate(context.Background(),
+				bson.M{"_id": userObjId, "$or": bson.A{
+					bson.M{"lastUpVote": bson.M{"$lte": votedAt.Add(-time.Minute)}},
+					bson.M{"lastUpVote": bson.M{"$exists": false}},
+				}},
+				bson.M{"$set": bson.M{"lastUpVote": votedAt}},
+				options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&user)
+			if err == mongo.ErrNoDocuments {
+				return c.Status(http.StatusForbidden).JSON(fiber.Map{
 					"ok":    false,
-					"error": "Internal Server Error",
+					"error": "User has voted in the last 1 minute",
 				})
 			}
-		} else {
-			_, err = postCollection.UpdateOne(context.Background(), bson.M{"_id": objId}, bson.M{"$push": bson.M{"upVotes": UserId}})
 			if err != nil {
 				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 					"ok":    false,
@@ -382,9 +988,29 @@ func VotePostById(db *mongo.Database, post fiber.Router) {
 			}
 		}
 
-		// reset user vote time
-		_, err = userCollection.UpdateOne(context.Background(), bson.M{"_id": objId}, bson.M{"$set": bson.M{"lastUpVote": time.Now()}})
-		if err != nil {
+		// move the user to the right list and recompute the score, the filter makes
+		// sure the same vote is never counted twice
+		updatedPost := models.Post{}
+		err = postCollection.FindOneAndUpdate(context.Background(),
+			voteFilter(objId, UserId, voteRequest.Vote),
+			voteUpdate(UserId, voteRequest.Vote),
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updatedPost)
+		if err != nil && voteRequest.Vote != VoteClear {
+			// give the cooldown back, the vote was not counted
+			_, _ = userCollection.UpdateOne(context.Background(),
+				bson.M{"_id": userObjId, "lastUpVote": votedAt},
+				bson.M{"$set": bson.M{"lastUpVote": user.LastUpVote}})
+		}
+		if err == mongo.ErrNoDocuments && voteRequest.Vote != VoteClear {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"ok":    false,
+				"error": "User has already voted",
+			})
+		}
+		if err == mongo.ErrNoDocuments {
+			// there was no vote to clear
+			updatedPost = post
+		} else if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Internal Server Error",
@@ -393,7 +1019,11 @@ func VotePostById(db *mongo.Database, post fiber.Router) {
 
 		return c.Status(http.StatusOK).JSON(fiber.Map{
 			"ok":      true,
-			"message": "post upvoted",
+			"message": voteMessages[voteRequest.Vote],
+			"data": fiber.Map{
+				"vote":  currentVote(updatedPost, UserId),
+				"score": updatedPost.Score,
+			},
 		})
 	})
 }
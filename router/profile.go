@@ -0,0 +1,136 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+)
+
+// count the published posts of a user and add up their scores as its karma
+func profileStats(db *mongo.Database, userId string) (int, int, error) {
+	cursor, err := db.Collection("Post").Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userId, "status": models.PostStatusPublished, "deletedAt": nil}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "count": bson.M{"$sum": 1}, "karma": bson.M{"$sum": "$score"}}}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	stats := []struct {
+		Count int `bson:"count"`
+		Karma int `bson:"karma"`
+	}{}
+	if err = cursor.All(context.Background(), &stats); err != nil {
+		return 0, 0, err
+	}
+	if len(stats) == 0 {
+		return 0, 0, nil
+	}
+	return stats[0].Count, stats[0].Karma, nil
+}
+
+func GetUserById(db *mongo.Database, user fiber.Router) {
+	user.Get("/:id", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Unauthorized",
+			})
+		}
+
+		// check if id is valid
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// the deactivated accounts and the ones being deleted are not shown
+		profile := models.User{}
+		err = db.Collection("User").FindOne(context.Background(),
+			bson.M{"_id": objId, "deactivatedAt": nil, "deletion": nil}).Decode(&profile)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "User not found",
+			})
+		}
+
+		postCount, karma, err := profileStats(db, objId.Hex())
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// get a page of published posts of the user, newest first
+		posts, nextCursor, err := findPostPage(db.Collection("Post"),
+			bson.M{"userId": objId.Hex(), "status": models.PostStatusPublished, "deletedAt": nil},
+			SortNew, pageLimit(c), c.Query("cursor"))
+		if err == errInvalidCursor {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid cursor",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		if err = markBookmarked(db, userID, posts); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// change nil array to empty array
+		for i, post := range posts {
+			if post.UpVotes == nil {
+				posts[i].UpVotes = []string{}
+			}
+			if post.DownVotes == nil {
+				posts[i].DownVotes = []string{}
+			}
+			if post.Tags == nil {
+				posts[i].Tags = []string{}
+			}
+			if post.Attachments == nil {
+				posts[i].Attachments = []models.Attachment{}
+			}
+			posts[i].AvatarUrl = models.AvatarUrl(post.UserId)
+		}
+
+		data := fiber.Map{
+			"id":        profile.ID.Hex(),
+			"firstName": profile.FirstName,
+			"avatarUrl": models.AvatarUrl(profile.ID.Hex()),
+			"createdAt": profile.CreatedAt,
+			"postCount": postCount,
+			"karma":     karma,
+			"posts":     posts,
+		}
+		// the email and the last name are private unless the user made them public
+		if profile.PublicProfile || userID == profile.ID.Hex() {
+			data["email"] = profile.Email
+			data["lastName"] = profile.LastName
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":         true,
+			"data":       data,
+			"nextCursor": nextCursor,
+		})
+	})
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDatabase connects to the MongoDB of the env MONGO_URI and gives a new
+// database that is dropped after the test. The test is skipped without it.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tokens are checked against the database named by the env
+	name := "test_" + primitive.NewObjectID().Hex()
+	t.Setenv("DB_NAME", name)
+	t.Setenv("SECRET_KEY", "test")
+	db := client.Database(name)
+	t.Cleanup(func() {
+		_ = db.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+	return db
+}
+
+// testUser registers a user and gives its id and the Authorization header of its token
+func testUser(t *testing.T, db *mongo.Database, firstName string) (string, string) {
+	t.Helper()
+	res, err := db.Collection("User").InsertOne(context.Background(), models.User{
+		CreatedAt:  time.Now(),
+		Email:      firstName + "@example.com",
+		FirstName:  firstName,
+		LastName:   "Test",
+		LastUpVote: time.Now().Add(-1 * time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId := res.InsertedID.(primitive.ObjectID).Hex()
+	return userId, "Bearer " + jwt.GetToken(userId)
+}
+
+// testPost publishes a post of a user and gives its id
+func testPost(t *testing.T, db *mongo.Database, userId string, firstName string) string {
+	t.Helper()
+	res, err := db.Collection("Post").InsertOne(context.Background(), models.Post{
+		CreatedAt: time.Now(),
+		UserId:    userId,
+		FirstName: firstName,
+		Title:     "title",
+		Content:   "content",
+		UpVotes:   []string{},
+		DownVotes: []string{},
+		Status:    models.PostStatusPublished,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.InsertedID.(primitive.ObjectID).Hex()
+}
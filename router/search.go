@@ -0,0 +1,100 @@
+package router
+
+import (
+	"containerized-go-app/models"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const snippetRadius = 80
+
+type searchResult struct {
+	models.Post    `bson:",inline"`
+	Relevance      float64 `json:"relevance" bson:"relevance"`
+	TitleHighlight string  `json:"titleHighlight" bson:"-"`
+	Snippet        string  `json:"snippet" bson:"-"`
+}
+
+// get the words of a text search, without the excluded ones
+func searchTerms(query string) []string {
+	terms := []string{}
+	for _, term := range strings.Fields(strings.ReplaceAll(query, "\"", " ")) {
+		if strings.HasPrefix(term, "-") {
+			continue
+		}
+		terms = append(terms, strings.ToLower(term))
+	}
+	return terms
+}
+
+// parse a date of a search filter, either a full timestamp or a day
+func parseSearchDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err == nil && endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, err
+}
+
+// escape the text and wrap the search terms in <mark> tags
+func highlight(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	var builder strings.Builder
+	for i := 0; i < len(text); {
+		matched := 0
+		for _, term := range terms {
+			// only compare when the lowercase text keeps the same byte offsets
+			if len(lower) == len(text) && strings.HasPrefix(lower[i:], term) && len(term) > matched {
+				matched = len(term)
+			}
+		}
+		if matched > 0 {
+			builder.WriteString("<mark>" + html.EscapeString(text[i:i+matched]) + "</mark>")
+			i += matched
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		builder.WriteString(html.EscapeString(text[i : i+size]))
+		i += size
+	}
+	return builder.String()
+}
+
+// cut the content around the first search term found, then highlight it
+func snippet(content string, terms []string) string {
+	lower := strings.ToLower(content)
+	start := -1
+	if len(lower) == len(content) {
+		for _, term := range terms {
+			if index := strings.Index(lower, term); index >= 0 && (start < 0 || index < start) {
+				start = index
+			}
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	from := max(start-snippetRadius, 0)
+	to := min(start+snippetRadius, len(content))
+	for from > 0 && !utf8.RuneStart(content[from]) {
+		from--
+	}
+	for to < len(content) && !utf8.RuneStart(content[to]) {
+		to++
+	}
+
+	result := highlight(content[from:to], terms)
+	if from > 0 {
+		result = "…" + result
+	}
+	if to < len(content) {
+		result += "…"
+	}
+	return result
+}
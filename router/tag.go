@@ -0,0 +1,96 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const maxTags = 5
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,29}$`)
+
+// lowercase, deduplicate and validate the tags of a post
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if !tagPattern.MatchString(tag) {
+			return nil, errors.New("invalid tag")
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, errors.New("too many tags")
+	}
+	return normalized, nil
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+func TagRoutes(app *fiber.App, db *mongo.Database) {
+	tags := app.Group("/tags", func(c *fiber.Ctx) error {
+		return c.Next()
+	})
+	GetTags(db, tags)
+}
+
+func GetTags(db *mongo.Database, tags fiber.Router) {
+	tags.Get("/", func(c *fiber.Ctx) error {
+		_, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// count the published posts of each tag, most used first
+		filter := bson.M{"status": models.PostStatusPublished, "authorHidden": nil, "deletedAt": nil}
+		postCollection := db.Collection("Post")
+		cursor, err := postCollection.Aggregate(context.Background(), mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$unwind", Value: "$tags"}},
+			{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+			{{Key: "$project", Value: bson.M{"_id": 0, "name": "$_id", "count": 1}}},
+		})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		type tagCount struct {
+			Name  string `json:"name" bson:"name"`
+			Count int    `json:"count" bson:"count"`
+		}
+		counts := []tagCount{}
+		if err = cursor.All(context.Background(), &counts); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": counts,
+		})
+	})
+}
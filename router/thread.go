@@ -0,0 +1,90 @@
+package router
+
+import (
+	"containerized-go-app/markdown"
+	"containerized-go-app/models"
+	"github.com/gofiber/fiber/v2"
+	"os"
+	"strconv"
+)
+
+const defaultCommentMaxDepth = 5
+
+// get the maximum depth of a thread, from the env or lowered by ?maxDepth=
+func commentMaxDepth(c *fiber.Ctx) int {
+	maxDepth := defaultCommentMaxDepth
+	if value, err := strconv.Atoi(os.Getenv("COMMENT_MAX_DEPTH")); err == nil && value >= 0 {
+		maxDepth = value
+	}
+	if value, err := strconv.Atoi(c.Query("maxDepth")); err == nil && value >= 0 && value < maxDepth {
+		maxDepth = value
+	}
+	return maxDepth
+}
+
+// build the threads of a post, replies deeper than maxDepth are kept at maxDepth
+func buildCommentTree(comments []models.Comment, maxDepth int) []*models.CommentNode {
+	nodes := map[string]*models.CommentNode{}
+	ordered := []*models.CommentNode{}
+	for _, comment := range comments {
+		node := &models.CommentNode{Comment: comment}
+		nodes[comment.ID] = node
+		ordered = append(ordered, node)
+	}
+
+	roots := []*models.CommentNode{}
+	for _, node := range ordered {
+		if node.ParentId == "" {
+			roots = append(roots, node)
+			continue
+		}
+
+		// replies to a comment that does not exist anymore hang under a placeholder
+		parent, ok := nodes[node.ParentId]
+		if !ok {
+			parent = &models.CommentNode{
+				Comment: models.Comment{
+					CreatedAt:   node.CreatedAt,
+					ID:          node.ParentId,
+					Content:     models.DeletedContent,
+					ContentHtml: markdown.Render(models.DeletedContent),
+					Deleted:     true,
+				},
+			}
+			nodes[node.ParentId] = parent
+			roots = append(roots, parent)
+		}
+		parent.Replies = append(parent.Replies, node)
+	}
+
+	return capCommentDepth(roots, 0, maxDepth)
+}
+
+func capCommentDepth(siblings []*models.CommentNode, depth int, maxDepth int) []*models.CommentNode {
+	capped := []*models.CommentNode{}
+	for _, node := range siblings {
+		node.Depth = depth
+		capped = append(capped, node)
+		if depth >= maxDepth {
+			// move the replies next to the node instead of going deeper
+			replies := node.Replies
+			node.Replies = nil
+			capped = append(capped, capCommentDepth(replies, depth, maxDepth)...)
+			continue
+		}
+		node.Replies = capCommentDepth(node.Replies, depth+1, maxDepth)
+	}
+	return capped
+}
+
+// flatten the threads in reading order, each node keeps its depth
+func flattenCommentTree(roots []*models.CommentNode) []*models.CommentNode {
+	flat := []*models.CommentNode{}
+	for _, node := range roots {
+		replies := node.Replies
+		node.Replies = nil
+		flat = append(flat, node)
+		flat = append(flat, flattenCommentTree(replies)...)
+	}
+	return flat
+}
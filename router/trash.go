@@ -0,0 +1,144 @@
+package router
+
+import (
+	"containerized-go-app/jwt"
+	"containerized-go-app/models"
+	"containerized-go-app/scheduler"
+	"containerized-go-app/trash"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+)
+
+// deleted posts keep their document until they are purged, every read path
+// filters them out with "deletedAt": nil which also matches the posts without it
+
+func GetTrash(db *mongo.Database, post fiber.Router) {
+	post.Get("/trash", func(c *fiber.Ctx) error {
+		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// get a page of the deleted posts of the user that can still be restored
+		postCollection := db.Collection("Post")
+		filter := bson.M{
+			"userId":    userID,
+			"deletedAt": bson.M{"$gte": time.Now().Add(-trash.Retention())},
+		}
+		posts, nextCursor, err := findPostPage(postCollection, filter, SortNew, pageLimit(c), c.Query("cursor"))
+		if err == errInvalidCursor {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid cursor",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// change nil array to empty array
+		for i, post := range posts {
+			if post.UpVotes == nil {
+				posts[i].UpVotes = []string{}
+			}
+			if post.DownVotes == nil {
+				posts[i].DownVotes = []string{}
+			}
+			if post.Tags == nil {
+				posts[i].Tags = []string{}
+			}
+			if post.Attachments == nil {
+				posts[i].Attachments = []models.Attachment{}
+			}
+			posts[i].AvatarUrl = models.AvatarUrl(post.UserId)
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":         true,
+			"data":       posts,
+			"nextCursor": nextCursor,
+		})
+	})
+}
+
+func RestorePostById(db *mongo.Database, publisher *scheduler.Scheduler, post fiber.Router) {
+	post.Post("/:id/restore", func(c *fiber.Ctx) error {
+		// get user id and authorization from token
+		UserId, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"ok":    false,
+				"error": "wrong token",
+			})
+		}
+
+		// check if id is valid
+		objId, _ := primitive.ObjectIDFromHex(c.Params("id"))
+		if objId.IsZero() {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Invalid ID",
+			})
+		}
+
+		// take the post out of the trash if it is still there
+		restored := models.Post{}
+		err = db.Collection("Post").FindOneAndUpdate(context.Background(),
+			bson.M{
+				"_id":       objId,
+				"userId":    UserId,
+				"deletedAt": bson.M{"$gte": time.Now().Add(-trash.Retention())},
+			},
+			bson.M{"$unset": bson.M{"deletedAt": ""}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&restored)
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Post not found in trash",
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
+
+		// a scheduled post may be due already
+		if restored.Status == models.PostStatusScheduled {
+			publisher.Wake()
+		}
+
+		// change nil array to empty array
+		if restored.UpVotes == nil {
+			restored.UpVotes = []string{}
+		}
+		if restored.DownVotes == nil {
+			restored.DownVotes = []string{}
+		}
+		if restored.Tags == nil {
+			restored.Tags = []string{}
+		}
+		if restored.Attachments == nil {
+			restored.Attachments = []models.Attachment{}
+		}
+		restored.AvatarUrl = models.AvatarUrl(restored.UserId)
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"ok":   true,
+			"data": restored,
+		})
+	})
+}
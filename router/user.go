@@ -1,24 +1,37 @@
 package router
 
 import (
+	"containerized-go-app/export"
 	"containerized-go-app/hash"
 	"containerized-go-app/jwt"
 	"containerized-go-app/models"
+	"containerized-go-app/storage"
 	"context"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"log"
 	"net/http"
 )
 
-func UserRoutes(app *fiber.App, db *mongo.Database) {
+func UserRoutes(app *fiber.App, db *mongo.Database, store storage.Storage, exporter *export.Exporter) {
 	user := app.Group("/user", func(c *fiber.Ctx) error {
 		return c.Next()
 	})
 	GetUser(db, user)
+	GetMyBookmarks(db, user)
+	GetMyNotifications(db, user)
+	ExportUser(db, exporter, user)
+	GetExport(db, user)
+	DownloadExport(db, store, user)
 	EditUser(db, user)
-	DeleteUser(db, user)
+	DeactivateUser(db, user)
+	DeleteUser(db, store, user)
+	UploadAvatar(db, store, user)
+	DeleteAvatar(db, store, user)
+	GetAvatar(db, store, user)
+	GetUserById(db, user)
 }
 
 func GetUser(db *mongo.Database, user fiber.Router) {
@@ -46,14 +59,30 @@ func GetUser(db *mongo.Database, user fiber.Router) {
 		return c.Status(http.StatusOK).JSON(fiber.Map{
 			"ok": true,
 			"data": fiber.Map{
-				"email":     user.Email,
-				"firstName": user.FirstName,
-				"lastName":  user.LastName,
+				"email":         user.Email,
+				"firstName":     user.FirstName,
+				"lastName":      user.LastName,
+				"publicProfile": user.PublicProfile,
+				"avatarUrl":     models.AvatarUrl(userID),
 			},
 		})
 	})
 }
 
+// copy the new first name of a user on all the posts and comments written by the user,
+// the comments that were deleted do not have an author anymore
+func propagateFirstName(db *mongo.Database, userId string, firstName string) error {
+	for _, name := range []string{"Post", "Comment"} {
+		_, err := db.Collection(name).UpdateMany(context.Background(),
+			bson.M{"userId": userId, "firstName": bson.M{"$ne": firstName}},
+			bson.M{"$set": bson.M{"firstName": firstName}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func EditUser(db *mongo.Database, user fiber.Router) {
 	user.Put("/edit", func(c *fiber.Ctx) error {
 		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
@@ -77,7 +106,10 @@ func EditUser(db *mongo.Database, user fiber.Router) {
 		}
 
 		var userUpdate models.User
-		if err := c.BodyParser(&userUpdate); err != nil {
+		var visibility struct {
+			PublicProfile *bool `json:"publicProfile"`
+		}
+		if err := c.BodyParser(&userUpdate); err != nil || c.BodyParser(&visibility) != nil {
 			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Bad Request",
@@ -94,6 +126,11 @@ func EditUser(db *mongo.Database, user fiber.Router) {
 		if userUpdate.Email != "" {
 			update["email"] = userUpdate.Email
 		}
+		publicProfile := user.PublicProfile
+		if visibility.PublicProfile != nil {
+			publicProfile = *visibility.PublicProfile
+			update["publicProfile"] = publicProfile
+		}
 		if userUpdate.Password != "" {
 			update["password"], err = hash.HashPassword(userUpdate.Password)
 			if err != nil {
@@ -112,18 +149,32 @@ func EditUser(db *mongo.Database, user fiber.Router) {
 			})
 		}
 
+		// posts and comments keep a copy of the name of their author, it is
+		// updated before answering so the next reads show the new name. It runs
+		// even if the name did not change so a rename that failed is finished by
+		// sending it again, the documents already up to date are skipped.
+		if userUpdate.FirstName != "" {
+			if err = propagateFirstName(db, userID, userUpdate.FirstName); err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"ok":    false,
+					"error": "Internal Server Error",
+				})
+			}
+		}
+
 		return c.Status(http.StatusOK).JSON(fiber.Map{
 			"ok": true,
 			"data": fiber.Map{
-				"email":     userUpdate.Email,
-				"firstName": userUpdate.FirstName,
-				"lastName":  userUpdate.LastName,
+				"email":         userUpdate.Email,
+				"firstName":     userUpdate.FirstName,
+				"lastName":      userUpdate.LastName,
+				"publicProfile": publicProfile,
 			},
 		})
 	})
 }
 
-func DeleteUser(db *mongo.Database, user fiber.Router) {
+func DeleteUser(db *mongo.Database, store storage.Storage, user fiber.Router) {
 	user.Delete("/remove", func(c *fiber.Ctx) error {
 		userID, err := jwt.GetUserID(c.Get("Authorization"), db.Client())
 		if err != nil {
@@ -133,25 +184,37 @@ func DeleteUser(db *mongo.Database, user fiber.Router) {
 			})
 		}
 
-		userCollection := db.Collection("User")
-
+		// mark the account first, its tokens stop working from here
 		objId, _ := primitive.ObjectIDFromHex(userID)
-		user := models.User{}
-		err = userCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&user)
-		if err != nil {
+		user, err := startAccountDeletion(db, objId, bson.M{})
+		if err == mongo.ErrNoDocuments {
 			return c.Status(http.StatusNotFound).JSON(fiber.Map{
 				"ok":    false,
 				"error": "Not Found",
 			})
 		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"ok":    false,
+				"error": "Internal Server Error",
+			})
+		}
 
-		return c.Status(http.StatusOK).JSON(fiber.Map{
+		// a deletion that fails part way is finished in the background
+		status := http.StatusOK
+		if err = deleteAccount(context.Background(), db, store, *user); err != nil {
+			log.Println("account deletion:", err)
+			status = http.StatusAccepted
+		}
+
+		return c.Status(status).JSON(fiber.Map{
 			"ok": true,
 			"data": fiber.Map{
 				"email":     user.Email,
 				"firstName": user.FirstName,
 				"lastName":  user.LastName,
 				"removed":   true,
+				"pending":   status == http.StatusAccepted,
 			},
 		})
 	})
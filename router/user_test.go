@@ -0,0 +1,55 @@
+package router
+
+import (
+	"containerized-go-app/models"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEditUserRenameShowsInFeed(t *testing.T) {
+	db := testDatabase(t)
+	userId, token := testUser(t, db, "Alice")
+	postId := testPost(t, db, userId, "Alice")
+
+	app := fiber.New()
+	EditUser(db, app.Group("/user"))
+	GetPosts(db, app.Group("/post"))
+
+	req := httptest.NewRequest(http.MethodPut, "/user/edit", strings.NewReader(`{"firstName":"Bob"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("edit status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// the feed read right after the rename already shows the new name
+	req = httptest.NewRequest(http.MethodGet, "/post", nil)
+	req.Header.Set("Authorization", token)
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("feed status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var feed struct {
+		Data []models.Post `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Data) != 1 || feed.Data[0].ID.Hex() != postId {
+		t.Fatalf("feed = %v, want the post %s", feed.Data, postId)
+	}
+	if feed.Data[0].FirstName != "Bob" {
+		t.Errorf("firstName = %q, want %q", feed.Data[0].FirstName, "Bob")
+	}
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"containerized-go-app/models"
+	"containerized-go-app/rank"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	VoteUp    = "up"
+	VoteDown  = "down"
+	VoteClear = "clear"
+)
+
+var voteMessages = map[string]string{
+	VoteUp:    "post upvoted",
+	VoteDown:  "post downvoted",
+	VoteClear: "vote removed",
+}
+
+// get the vote of a user on a post, "clear" if there is none
+func currentVote(post models.Post, userId string) string {
+	for _, id := range post.UpVotes {
+		if id == userId {
+			return VoteUp
+		}
+	}
+	for _, id := range post.DownVotes {
+		if id == userId {
+			return VoteDown
+		}
+	}
+	return VoteClear
+}
+
+// match the post only if the vote changes something
+func voteFilter(postId primitive.ObjectID, userId string, vote string) bson.M {
+	switch vote {
+	case VoteUp:
+		return bson.M{"_id": postId, "upVotes": bson.M{"$ne": userId}}
+	case VoteDown:
+		return bson.M{"_id": postId, "downVotes": bson.M{"$ne": userId}}
+	default:
+		return bson.M{"_id": postId, "$or": bson.A{bson.M{"upVotes": userId}, bson.M{"downVotes": userId}}}
+	}
+}
+
+// build the update pipeline that puts the user in the list of its vote and
+// recomputes the scores, missing lists are treated as empty
+func voteUpdate(userId string, vote string) bson.A {
+	upVotes := bson.M{"$setDifference": bson.A{bson.M{"$ifNull": bson.A{"$upVotes", bson.A{}}}, bson.A{userId}}}
+	downVotes := bson.M{"$setDifference": bson.A{bson.M{"$ifNull": bson.A{"$downVotes", bson.A{}}}, bson.A{userId}}}
+	switch vote {
+	case VoteUp:
+		upVotes = bson.M{"$concatArrays": bson.A{upVotes, bson.A{userId}}}
+	case VoteDown:
+		downVotes = bson.M{"$concatArrays": bson.A{downVotes, bson.A{userId}}}
+	}
+
+	return bson.A{
+		bson.M{"$set": bson.M{"upVotes": upVotes, "downVotes": downVotes}},
+		bson.M{"$set": bson.M{"score": bson.M{"$subtract": bson.A{bson.M{"$size": "$upVotes"}, bson.M{"$size": "$downVotes"}}}}},
+		bson.M{"$set": bson.M{"hotScore": rank.HotExpression()}},
+	}
+}
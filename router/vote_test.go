@@ -0,0 +1,170 @@
+package router
+
+import (
+	"containerized-go-app/models"
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestVotePostByIdConcurrent(t *testing.T) {
+	db := testDatabase(t)
+	authorId, _ := testUser(t, db, "Author")
+	postId := testPost(t, db, authorId, "Author")
+	userId, token := testUser(t, db, "Voter")
+
+	app := fiber.New()
+	VotePostById(db, app.Group("/post"))
+
+	// the same user votes many times at once, a single vote must go through
+	const requests = 20
+	statuses := make(chan int, requests)
+	wg := sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/post/vote/"+postId, nil)
+			req.Header.Set("Authorization", token)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counted := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			counted++
+		case http.StatusForbidden:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if counted != 1 {
+		t.Fatalf("%d requests went past the cooldown, want 1", counted)
+	}
+
+	objId, _ := primitive.ObjectIDFromHex(postId)
+	post := models.Post{}
+	if err := db.Collection("Post").FindOne(context.Background(), bson.M{"_id": objId}).Decode(&post); err != nil {
+		t.Fatal(err)
+	}
+	if len(post.UpVotes) != 1 || post.UpVotes[0] != userId {
+		t.Errorf("upVotes = %v, want [%s]", post.UpVotes, userId)
+	}
+	if len(post.DownVotes) != 0 {
+		t.Errorf("downVotes = %v, want []", post.DownVotes)
+	}
+	if post.Score != 1 {
+		t.Errorf("score = %d, want 1", post.Score)
+	}
+}
+
+func TestVoteUpdateConcurrent(t *testing.T) {
+	db := testDatabase(t)
+	authorId, _ := testUser(t, db, "Author")
+	postId := testPost(t, db, authorId, "Author")
+	userId, _ := testUser(t, db, "Voter")
+	objId, _ := primitive.ObjectIDFromHex(postId)
+	postCollection := db.Collection("Post")
+
+	// the cooldown is out of the way, the filter alone must keep the vote unique
+	const requests = 20
+	results := make(chan error, requests)
+	wg := sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- postCollection.FindOneAndUpdate(context.Background(),
+				voteFilter(objId, userId, VoteUp),
+				voteUpdate(userId, VoteUp),
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Err()
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	counted := 0
+	for err := range results {
+		switch err {
+		case nil:
+			counted++
+		case mongo.ErrNoDocuments:
+		default:
+			t.Error(err)
+		}
+	}
+	if counted != 1 {
+		t.Errorf("%d updates matched the post, want 1", counted)
+	}
+
+	post := models.Post{}
+	if err := postCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&post); err != nil {
+		t.Fatal(err)
+	}
+	if len(post.UpVotes) != 1 || post.UpVotes[0] != userId {
+		t.Errorf("upVotes = %v, want [%s]", post.UpVotes, userId)
+	}
+	if post.Score != 1 {
+		t.Errorf("score = %d, want 1", post.Score)
+	}
+}
+
+func TestVoteUpdateConcurrentUsers(t *testing.T) {
+	db := testDatabase(t)
+	authorId, _ := testUser(t, db, "Author")
+	postId := testPost(t, db, authorId, "Author")
+	objId, _ := primitive.ObjectIDFromHex(postId)
+	postCollection := db.Collection("Post")
+
+	// the votes of different users sent at once are all counted, each one twice
+	// to also race against itself
+	const users = 10
+	wg := sync.WaitGroup{}
+	for i := 0; i < users; i++ {
+		userId := primitive.NewObjectID().Hex()
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := postCollection.FindOneAndUpdate(context.Background(),
+					voteFilter(objId, userId, VoteUp),
+					voteUpdate(userId, VoteUp)).Err()
+				if err != nil && err != mongo.ErrNoDocuments {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	post := models.Post{}
+	if err := postCollection.FindOne(context.Background(), bson.M{"_id": objId}).Decode(&post); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, id := range post.UpVotes {
+		if seen[id] {
+			t.Errorf("%s is twice in upVotes", id)
+		}
+		seen[id] = true
+	}
+	if len(post.UpVotes) != users || post.Score != users {
+		t.Errorf("upVotes = %d, score = %d, want %d", len(post.UpVotes), post.Score, users)
+	}
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"containerized-go-app/models"
+	"containerized-go-app/rank"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+// how long to wait when there is nothing scheduled or the database failed
+const idleDelay = time.Hour
+const retryDelay = time.Minute
+
+// Scheduler publishes the scheduled posts at their publishAt date. Nothing is
+// kept in memory: every run looks for the posts that are due in the collection,
+// so the posts scheduled before a restart are published once the server is back.
+type Scheduler struct {
+	db   *mongo.Database
+	wake chan struct{}
+}
+
+func New(db *mongo.Database) *Scheduler {
+	return &Scheduler{db: db, wake: make(chan struct{}, 1)}
+}
+
+// Wake makes the scheduler look at the collection again, it must be called
+// when a post is scheduled so an earlier date is not missed
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes the posts that are due then sleeps until the next one, until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		delay := idleDelay
+		next, err := s.publishDue(ctx)
+		if err != nil {
+			log.Println("scheduler:", err)
+			delay = retryDelay
+		} else if next != nil {
+			delay = time.Until(*next)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// publish the posts whose date has passed and get the date of the next one
+func (s *Scheduler) publishDue(ctx context.Context) (*time.Time, error) {
+	postCollection := s.db.Collection("Post")
+
+	// the post takes its publication date as creation date so it shows up
+	// at the top of the feeds, the filter makes it safe to run on several servers
+	_, err := postCollection.UpdateMany(ctx,
+		bson.M{"status": models.PostStatusScheduled, "publishAt": bson.M{"$lte": time.Now()}, "deletedAt": nil},
+		bson.A{
+			bson.M{"$set": bson.M{"status": models.PostStatusPublished, "createdAt": "$publishAt"}},
+			bson.M{"$set": bson.M{"hotScore": rank.HotExpression()}},
+			bson.M{"$unset": "publishAt"},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	next := models.Post{}
+	err = postCollection.FindOne(ctx,
+		bson.M{"status": models.PostStatusScheduled, "deletedAt": nil},
+		options.FindOne().SetSort(bson.D{{Key: "publishAt", Value: 1}})).Decode(&next)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return next.PublishAt, nil
+}
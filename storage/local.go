@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// keys are generated by the server, anything else could escape the directory
+var keyPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Local stores files in a directory of the local filesystem
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !keyPattern.MatchString(key) {
+		return "", errors.New("invalid key")
+	}
+	return filepath.Join(l.dir, key), nil
+}
+
+// Save writes to a temporary file first so a failed upload never leaves a partial file
+func (l *Local) Save(key string, r io.Reader) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Open(key string) (File, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &localFile{File: f, info: info}, nil
+}
+
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+type localFile struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (f *localFile) Size() int64 {
+	return f.info.Size()
+}
+
+func (f *localFile) ModTime() time.Time {
+	return f.info.ModTime()
+}
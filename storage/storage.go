@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Storage keeps uploaded files by key, the local filesystem is the default
+// driver and others (S3 compatible, ...) only have to implement it
+type Storage interface {
+	Save(key string, r io.Reader) error
+	Open(key string) (File, error)
+	Delete(key string) error
+}
+
+// File is a stored file opened for reading, seeking is needed to serve ranges
+type File interface {
+	io.ReadSeekCloser
+	Size() int64
+	ModTime() time.Time
+}
@@ -0,0 +1,103 @@
+package trash
+
+import (
+	"containerized-go-app/models"
+	"containerized-go-app/storage"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultRetentionDays = 30
+
+// how often the expired posts are looked for
+const purgeInterval = time.Hour
+
+// Retention is how long a deleted post stays in the trash of its author,
+// from the env TRASH_RETENTION_DAYS
+func Retention() time.Duration {
+	days := defaultRetentionDays
+	if value, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS")); err == nil && value >= 0 {
+		days = value
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Run purges the expired posts now and then every purgeInterval, until ctx is done
+func Run(ctx context.Context, db *mongo.Database, store storage.Storage) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		if err := Purge(ctx, db, store); err != nil {
+			log.Println("trash:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes for good the posts deleted longer than the retention ago,
+// with their comments, their history, their bookmarks and their files
+func Purge(ctx context.Context, db *mongo.Database, store storage.Storage) error {
+	postCollection := db.Collection("Post")
+	expired := bson.M{"deletedAt": bson.M{"$lt": time.Now().Add(-Retention())}}
+	cursor, err := postCollection.Find(ctx, expired)
+	if err != nil {
+		return err
+	}
+	posts := []models.Post{}
+	if err = cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+
+	// the post goes last so a purge that failed part way finds it again. An
+	// expired post cannot be restored anymore, its data is safe to remove.
+	for _, post := range posts {
+		if err = deletePostData(ctx, db, store, post); err != nil {
+			return err
+		}
+		_, err = postCollection.DeleteOne(ctx, bson.M{"_id": post.ID, "deletedAt": expired["deletedAt"]})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePost removes a post for good with everything attached to it. The post
+// itself goes last so a failed call can be run again.
+func DeletePost(ctx context.Context, db *mongo.Database, store storage.Storage, post models.Post) error {
+	if err := deletePostData(ctx, db, store, post); err != nil {
+		return err
+	}
+	_, err := db.Collection("Post").DeleteOne(ctx, bson.M{"_id": post.ID})
+	return err
+}
+
+// remove the comments, the history, the bookmarks and the files of a post
+func deletePostData(ctx context.Context, db *mongo.Database, store storage.Storage, post models.Post) error {
+	if _, err := db.Collection("Comment").DeleteMany(ctx, bson.M{"postId": post.ID.Hex()}); err != nil {
+		return err
+	}
+	if _, err := db.Collection("PostRevision").DeleteMany(ctx, bson.M{"postId": post.ID.Hex()}); err != nil {
+		return err
+	}
+	if _, err := db.Collection("Bookmark").DeleteMany(ctx, bson.M{"postId": post.ID.Hex()}); err != nil {
+		return err
+	}
+	for _, attachment := range post.Attachments {
+		for _, key := range attachment.Keys() {
+			if err := store.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}